@@ -0,0 +1,172 @@
+package gmemdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var gcommitSeq uint64
+
+// nextCommitSeq 分配提交序列号,每个提交的事物和每个非事物操作分配一个
+func nextCommitSeq() uint64 {
+	return atomic.AddUint64(&gcommitSeq, 1)
+}
+
+// LastCommitSeq 最近一次提交的序列号
+func LastCommitSeq() uint64 {
+	return atomic.LoadUint64(&gcommitSeq)
+}
+
+// ErrChangeLogTruncated 请求的变更已经被移出环形缓冲区
+var ErrChangeLogTruncated = errors.New("变更日志已被截断")
+
+// ChangeBatch 同一次提交产生的变更,Old/New为提交时对象的浅拷贝
+type ChangeBatch struct {
+	Seq     uint64
+	Reason  int32
+	Changes []Change
+}
+
+// ChangeLog 变更日志,按提交序列号记录关联表的变更,保留最近capacity次提交,
+// 不受notify参数影响,可在其他goroutine中读取
+type ChangeLog struct {
+	lock      sync.Mutex
+	batches   []ChangeBatch
+	head      int
+	count     int
+	truncated uint64
+	factories []*ObjectFactory
+}
+
+// NewChangeLog 新建变更日志
+func NewChangeLog(capacity int, factories ...*ObjectFactory) *ChangeLog {
+	if capacity <= 0 {
+		formatndPanic("NewChangeLog: 无效的容量%d", capacity)
+	}
+	log := &ChangeLog{batches: make([]ChangeBatch, capacity)}
+	log.Attach(factories...)
+	return log
+}
+
+// Attach 记录表的变更
+func (s *ChangeLog) Attach(factories ...*ObjectFactory) {
+	for _, factory := range factories {
+		s.Detach(factory)
+		factory.changeLogs = append(factory.changeLogs, s)
+		s.factories = append(s.factories, factory)
+	}
+}
+
+// Detach 不再记录表的变更
+func (s *ChangeLog) Detach(factories ...*ObjectFactory) {
+	for _, factory := range factories {
+		for i, log := range factory.changeLogs {
+			if log == s {
+				factory.changeLogs = append(factory.changeLogs[:i], factory.changeLogs[i+1:]...)
+				break
+			}
+		}
+		for i, f := range s.factories {
+			if f == factory {
+				s.factories = append(s.factories[:i], s.factories[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Close 不再记录任何表的变更
+func (s *ChangeLog) Close() {
+	s.Detach(append([]*ObjectFactory(nil), s.factories...)...)
+}
+
+// FirstSeq 缓冲区中最早的提交序列号,缓冲区为空时返回0
+func (s *ChangeLog) FirstSeq() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return s.batches[s.head].Seq
+}
+
+// LastSeq 缓冲区中最新的提交序列号,缓冲区为空时返回0
+func (s *ChangeLog) LastSeq() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return s.batches[s.index(s.count-1)].Seq
+}
+
+// ReadSince 按顺序返回序列号大于seq的提交,最多max个(max<=0表示不限制),
+// seq之后的提交已被移出缓冲区时返回ErrChangeLogTruncated
+func (s *ChangeLog) ReadSince(seq uint64, max int) ([]ChangeBatch, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if seq < s.truncated {
+		return nil, fmt.Errorf("读取序列号%d之后的变更失败, 最早可读取%d: %w", seq, s.truncated, ErrChangeLogTruncated)
+	}
+	var batches []ChangeBatch
+	for i := 0; i < s.count; i++ {
+		batch := &s.batches[s.index(i)]
+		if batch.Seq <= seq {
+			continue
+		}
+		if max > 0 && len(batches) >= max {
+			break
+		}
+		batches = append(batches, ChangeBatch{
+			Seq:     batch.Seq,
+			Reason:  batch.Reason,
+			Changes: append([]Change(nil), batch.Changes...),
+		})
+	}
+	return batches, nil
+}
+
+func (s *ChangeLog) index(i int) int {
+	return (s.head + i) % len(s.batches)
+}
+
+func (s *ChangeLog) append(seq uint64, reason int32, change Change) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// 提交过程中触发器发起的非事物操作会插入序列号更大的提交
+	for i := s.count - 1; i >= 0; i-- {
+		batch := &s.batches[s.index(i)]
+		if batch.Seq == seq {
+			batch.Changes = append(batch.Changes, change)
+			return
+		} else if batch.Seq < seq {
+			break
+		}
+	}
+	if s.count == len(s.batches) {
+		s.truncated = s.batches[s.head].Seq
+		s.batches[s.head] = ChangeBatch{}
+		s.head = s.index(1)
+		s.count--
+	}
+	s.batches[s.index(s.count)] = ChangeBatch{Seq: seq, Reason: reason, Changes: []Change{change}}
+	s.count++
+}
+
+func (s *ObjectFactory) logChange(seq uint64, op ChangeOp, oldObj IObject, newObj IObject, reason int32) {
+	if len(s.changeLogs) == 0 {
+		return
+	}
+	change := Change{
+		Op:        op,
+		FactoryID: s.FactoryID,
+		Table:     s.Name,
+		Old:       copyObject(oldObj),
+		New:       copyObject(newObj),
+	}
+	for _, log := range s.changeLogs {
+		log.append(seq, reason, change)
+	}
+}
@@ -0,0 +1,122 @@
+package gmemdb
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// ValidatorFunc 对象校验函数,返回错误时新增或更新失败
+type ValidatorFunc func(obj IObject) error
+
+// FieldCheck 字段检查函数
+type FieldCheck func(val reflect.Value) error
+
+type fieldCheck struct {
+	field reflect.StructField
+	check FieldCheck
+}
+
+// ValidationError 校验失败,Field为空表示由ValidatorFunc返回
+type ValidationError struct {
+	Table string
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("表[%s]校验失败: %s", e.Table, e.Err.Error())
+	}
+	return fmt.Sprintf("表[%s]字段[%s]校验失败: %s", e.Table, e.Field, e.Err.Error())
+}
+
+// Unwrap 返回校验函数的原始错误
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// AddValidator 添加校验函数,在新增和更新修改索引前按添加顺序执行
+func (s *ObjectFactory) AddValidator(fn ValidatorFunc) {
+	s.validators = append(s.validators, fn)
+}
+
+// AddCheck 给字段添加检查
+func (s *ObjectFactory) AddCheck(fieldName string, checks ...FieldCheck) {
+	field, ok := s.Type.FieldByName(fieldName)
+	if !ok {
+		formatndPanic("表[%s]添加字段检查失败: 列[%s]不存在", s.Name, fieldName)
+	}
+	for _, check := range checks {
+		s.checks = append(s.checks, fieldCheck{field: field, check: check})
+	}
+}
+
+func (s *ObjectFactory) validate(obj IObject) error {
+	if len(s.checks) > 0 {
+		val := reflect.ValueOf(obj).Elem()
+		for _, c := range s.checks {
+			if err := c.check(val.FieldByIndex(c.field.Index)); err != nil {
+				return &ValidationError{Table: s.Name, Field: c.field.Name, Err: err}
+			}
+		}
+	}
+	for _, fn := range s.validators {
+		if err := fn(obj); err != nil {
+			return &ValidationError{Table: s.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// CheckNotEmpty 字符串、切片、map长度不为0,其他类型不为零值
+func CheckNotEmpty() FieldCheck {
+	return func(val reflect.Value) error {
+		switch val.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+			if val.Len() == 0 {
+				return fmt.Errorf("不能为空")
+			}
+		default:
+			if val.IsZero() {
+				return fmt.Errorf("不能为空")
+			}
+		}
+		return nil
+	}
+}
+
+// CheckRange 数值在[min, max]范围内
+func CheckRange(min float64, max float64) FieldCheck {
+	return func(val reflect.Value) error {
+		var f float64
+		switch val.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(val.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f = float64(val.Uint())
+		case reflect.Float32, reflect.Float64:
+			f = val.Float()
+		default:
+			return fmt.Errorf("不支持范围检查的类型[%s]", val.Type())
+		}
+		if f < min || f > max {
+			return fmt.Errorf("%v 超出范围[%v, %v]", f, min, max)
+		}
+		return nil
+	}
+}
+
+// CheckRegexp 字符串匹配正则表达式
+func CheckRegexp(pattern string) FieldCheck {
+	re := regexp.MustCompile(pattern)
+	return func(val reflect.Value) error {
+		if val.Kind() != reflect.String {
+			return fmt.Errorf("不支持正则检查的类型[%s]", val.Type())
+		}
+		if !re.MatchString(val.String()) {
+			return fmt.Errorf("%q 不匹配 %s", val.String(), pattern)
+		}
+		return nil
+	}
+}
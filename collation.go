@@ -0,0 +1,42 @@
+package gmemdb
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Collation 字符串子键的排序规则,把字符串转换为按字节比较的排序key,
+// 排序key相同的字符串在索引中相等(唯一索引中冲突),查找时使用相同的规则;
+// 使用排序规则的子键不能解码还原
+type Collation func(s string) []byte
+
+// BinaryCollation 按UTF-8字节排序,和不设置排序规则相同
+func BinaryCollation() Collation {
+	return func(s string) []byte {
+		return []byte(s)
+	}
+}
+
+// FoldCollation 按Unicode大小写折叠后排序,不区分大小写
+func FoldCollation() Collation {
+	return func(s string) []byte {
+		// cases.Caser有内部状态,不能在多个goroutine间共享
+		return []byte(cases.Fold().String(s))
+	}
+}
+
+// NFCCollation 按Unicode NFC规范化后排序,组合字符和预组合字符相等
+func NFCCollation() Collation {
+	return func(s string) []byte {
+		return norm.NFC.Bytes([]byte(s))
+	}
+}
+
+// LocaleCollation 使用调用方提供的排序key函数,如golang.org/x/text/collate的
+// Collator.KeyFromString或拼音、笔画转换
+func LocaleCollation(sortKey func(s string) []byte) Collation {
+	if sortKey == nil {
+		formatndPanic("LocaleCollation: 排序key函数不能为空")
+	}
+	return Collation(sortKey)
+}
@@ -0,0 +1,187 @@
+package gmemdb_test
+
+import (
+	"errors"
+
+	"github.com/jxlczjp77/gmemdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type dbMemberObj struct {
+	gmemdb.ObjectBase
+	GuildName string
+	Name      string
+	Level     int32
+}
+
+type memberMDB struct {
+	gmemdb.ObjectFactory
+}
+
+func newMemberMDB() *memberMDB {
+	db := &memberMDB{}
+	db.Init("memberMDB", (*dbMemberObj)(nil), nil)
+	db.AddIndex("Name", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error { return key.AppendString(obj.(*dbMemberObj).Name) }, true)
+	db.AddIndex("GuildName", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+		return key.AppendString(obj.(*dbMemberObj).GuildName)
+	}, false)
+	return db
+}
+
+func (s *memberMDB) findByName(name string) gmemdb.Iterator {
+	return s.FindByIndexName("Name").AppendString(name).Fire()
+}
+
+func (s *memberMDB) countByGuild(guild string) int {
+	n := 0
+	for it := s.FindByIndexName("GuildName").AppendString(guild).Fire(); it.Next(); {
+		n++
+	}
+	return n
+}
+
+var _ = Describe("约束测试", func() {
+	var guilds *testObjMDB
+	var members *memberMDB
+	BeforeEach(func() {
+		guilds = newTestObjMDB(false)
+		guilds.Add(&dbTestObj{Name: "公会1"}, nil, 0)
+		guilds.Add(&dbTestObj{Name: "公会2"}, nil, 0)
+		members = newMemberMDB()
+	})
+
+	addMembers := func() {
+		Expect(members.TryAdd(&dbMemberObj{GuildName: "公会1", Name: "张三"}, nil, 0)).Should(Succeed())
+		Expect(members.TryAdd(&dbMemberObj{GuildName: "公会1", Name: "李四"}, nil, 0)).Should(Succeed())
+		Expect(members.TryAdd(&dbMemberObj{GuildName: "公会2", Name: "王五"}, nil, 0)).Should(Succeed())
+		Expect(members.TryAdd(&dbMemberObj{Name: "赵六"}, nil, 0)).Should(Succeed())
+	}
+
+	It("外键restrict测试", func() {
+		// 字段变换不一致的索引不能作为外键
+		lowerIdx := members.AddIndexWithOptions("GuildName#lower", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbMemberObj).GuildName)
+		}, gmemdb.IndexOptions{Transforms: map[string]gmemdb.KeyTransform{"GuildName": gmemdb.LowerCase()}})
+		Expect(func() { members.AddForeignKey(lowerIdx, &guilds.ObjectFactory, 1, gmemdb.FKRestrict) }).Should(Panic())
+
+		members.AddForeignKey(2, &guilds.ObjectFactory, 1, gmemdb.FKRestrict)
+		addMembers()
+		err := members.TryAdd(&dbMemberObj{GuildName: "公会3", Name: "孙七"}, nil, 0)
+		Expect(err).Should(BeAssignableToTypeOf(&gmemdb.ForeignKeyError{}))
+		Expect(members.findByName("孙七").Step()).Should(BeNil())
+
+		// 更新子表外键到不存在的父表记录
+		zs := members.findByName("张三").Step().(*dbMemberObj)
+		zsTmp := *zs
+		zsTmp.GuildName = "公会3"
+		Expect(members.TryUpdate(zs, &zsTmp, nil, 0)).ShouldNot(Succeed())
+
+		g1 := guilds.findByName("公会1").Step()
+		Expect(guilds.TryRemove(g1, nil, 0)).ShouldNot(Succeed())
+		Expect(guilds.findByName("公会1").Step()).ShouldNot(BeNil())
+
+		// 父表key变化时同样受限制
+		g2 := guilds.findByName("公会2").Step().(*dbTestObj)
+		g2Tmp := g2.Clone()
+		g2Tmp.Name = "公会22"
+		Expect(guilds.Update(g2, g2Tmp, nil, 0)).Should(BeFalse())
+		g2Tmp = g2.Clone()
+		g2Tmp.Address = "地址"
+		Expect(guilds.Update(g2, g2Tmp, nil, 0)).Should(BeTrue())
+	})
+
+	It("外键cascade测试", func() {
+		members.AddForeignKey(2, &guilds.ObjectFactory, 1, gmemdb.FKCascade)
+		addMembers()
+		transaction := gmemdb.NewTransaction()
+		g1 := guilds.findByName("公会1").Step()
+		Expect(guilds.TryRemove(g1, transaction, 0)).Should(Succeed())
+		Expect(members.countByGuild("公会1")).Should(BeZero())
+		Expect(members.Count()).Should(Equal(2))
+
+		transaction.Rollback()
+		Expect(guilds.findByName("公会1").Step()).ShouldNot(BeNil())
+		Expect(members.countByGuild("公会1")).Should(Equal(2))
+
+		// 级联修改使用的回滚点成功后释放,不影响调用方的回滚点
+		members.Add(&dbMemberObj{Name: "孙七"}, transaction, 0)
+		savePoint := transaction.AllocSavePoint()
+		Expect(guilds.TryRemove(g1, transaction, 0)).Should(Succeed())
+		Expect(transaction.LastSavePointID()).Should(Equal(0))
+		Expect(members.countByGuild("公会1")).Should(BeZero())
+		savePoint.Rollback()
+		Expect(guilds.findByName("公会1").Step()).ShouldNot(BeNil())
+		Expect(members.countByGuild("公会1")).Should(Equal(2))
+		Expect(members.findByName("孙七").Step()).ShouldNot(BeNil())
+		Expect(guilds.TryRemove(g1, transaction, 0)).Should(Succeed())
+		Expect(transaction.LastSavePointID()).Should(Equal(-1))
+		transaction.Rollback()
+		Expect(members.findByName("孙七").Step()).Should(BeNil())
+
+		Expect(guilds.TryRemove(g1, nil, 0)).Should(Succeed())
+		Expect(members.countByGuild("公会1")).Should(BeZero())
+		Expect(members.countByGuild("公会2")).Should(Equal(1))
+	})
+
+	It("外键set-null测试", func() {
+		members.AddForeignKey(2, &guilds.ObjectFactory, 1, gmemdb.FKSetNull)
+		addMembers()
+		g1 := guilds.findByName("公会1").Step()
+		Expect(guilds.TryRemove(g1, nil, 0)).Should(Succeed())
+		Expect(members.countByGuild("公会1")).Should(BeZero())
+		Expect(members.countByGuild("公会2")).Should(Equal(1))
+		Expect(members.Count()).Should(Equal(4))
+		Expect(members.findByName("张三").Step().(*dbMemberObj).GuildName).Should(BeEmpty())
+
+		// 级联修改失败时只回滚本次级联修改,事物中之前的修改保留
+		members.AddValidator(func(obj gmemdb.IObject) error {
+			if m := obj.(*dbMemberObj); m.Name == "周八" && m.GuildName == "" {
+				return errors.New("周八必须属于公会")
+			}
+			return nil
+		})
+		Expect(members.TryAdd(&dbMemberObj{GuildName: "公会2", Name: "孙七"}, nil, 0)).Should(Succeed())
+		transaction := gmemdb.NewTransaction()
+		Expect(members.TryAdd(&dbMemberObj{GuildName: "公会2", Name: "周八"}, transaction, 0)).Should(Succeed())
+		g2 := guilds.findByName("公会2").Step()
+		Expect(guilds.TryRemove(g2, transaction, 0)).ShouldNot(Succeed())
+		Expect(members.countByGuild("公会2")).Should(Equal(3))
+		transaction.Commit(0)
+		Expect(guilds.findByName("公会2").Step()).ShouldNot(BeNil())
+		Expect(members.findByName("周八").Step()).ShouldNot(BeNil())
+		Expect(members.countByGuild("公会2")).Should(Equal(3))
+	})
+
+	It("字段检查及校验函数测试", func() {
+		members.AddCheck("Name", gmemdb.CheckNotEmpty(), gmemdb.CheckRegexp(`^\S+$`))
+		members.AddCheck("Level", gmemdb.CheckRange(0, 100))
+		errTooLong := errors.New("名字太长")
+		members.AddValidator(func(obj gmemdb.IObject) error {
+			if len(obj.(*dbMemberObj).Name) > 12 {
+				return errTooLong
+			}
+			return nil
+		})
+
+		Expect(members.TryAdd(&dbMemberObj{Name: "张三", Level: 10}, nil, 0)).Should(Succeed())
+		err := members.TryAdd(&dbMemberObj{Name: "", Level: 10}, nil, 0)
+		Expect(err).Should(BeAssignableToTypeOf(&gmemdb.ValidationError{}))
+		Expect(err.(*gmemdb.ValidationError).Field).Should(Equal("Name"))
+		Expect(members.TryAdd(&dbMemberObj{Name: "李 四", Level: 10}, nil, 0)).ShouldNot(Succeed())
+		Expect(members.TryAdd(&dbMemberObj{Name: "王五", Level: 101}, nil, 0)).ShouldNot(Succeed())
+		Expect(errors.Is(members.TryAdd(&dbMemberObj{Name: "王五王五王五王五", Level: 1}, nil, 0), errTooLong)).Should(BeTrue())
+		Expect(members.Count()).Should(Equal(1))
+
+		transaction := gmemdb.NewTransaction()
+		zs := members.findByName("张三").Step().(*dbMemberObj)
+		zsTmp := *zs
+		zsTmp.Level = -1
+		Expect(members.Update(zs, &zsTmp, transaction, 0)).Should(BeFalse())
+		Expect(transaction.LastError()).Should(BeAssignableToTypeOf(&gmemdb.ValidationError{}))
+		Expect(transaction.LastError().(*gmemdb.ValidationError).Field).Should(Equal("Level"))
+		Expect(members.findByName("张三").Step().(*dbMemberObj).Level).Should(BeEquivalentTo(10))
+		transaction.Commit(0)
+		Expect(transaction.LastError()).Should(BeNil())
+	})
+})
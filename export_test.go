@@ -0,0 +1,8 @@
+package gmemdb
+
+// UnregisterReason 注销修改原因,测试重复运行时使用
+func UnregisterReason(code int32) {
+	greasonLock.Lock()
+	defer greasonLock.Unlock()
+	delete(greasons, Reason(code))
+}
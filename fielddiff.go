@@ -0,0 +1,109 @@
+package gmemdb
+
+import (
+	"reflect"
+	"sync"
+)
+
+// ChangedFields 更新前后发生变化的字段名,按结构体字段定义顺序排列
+type ChangedFields []string
+
+// Has 字段是否发生变化
+func (s ChangedFields) Has(name string) bool {
+	for _, field := range s {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAny 任一字段发生变化
+func (s ChangedFields) HasAny(names ...string) bool {
+	for _, name := range names {
+		if s.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+type diffField struct {
+	name       string
+	index      []int
+	comparable bool
+}
+
+// 每种类型可比较的字段只需解析一次
+var gdiffFields sync.Map
+
+func typeDiffFields(t reflect.Type) []diffField {
+	if v, ok := gdiffFields.Load(t); ok {
+		return v.([]diffField)
+	}
+	fields := make([]diffField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// 未导出字段不参与比较
+			continue
+		}
+		fields = append(fields, diffField{
+			name:       field.Name,
+			index:      field.Index,
+			comparable: field.Type.Comparable() && !containsInterface(field.Type),
+		})
+	}
+	v, _ := gdiffFields.LoadOrStore(t, fields)
+	return v.([]diffField)
+}
+
+// containsInterface 类型中是否包含接口,接口的动态值不可比较时==会panic
+func containsInterface(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Array:
+		return containsInterface(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if containsInterface(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ChangedFields 比较更新前后的对象,返回发生变化的导出字段
+func (s *ObjectFactory) ChangedFields(oldObj IObject, newObj IObject) ChangedFields {
+	oldVal := reflect.ValueOf(oldObj).Elem()
+	newVal := reflect.ValueOf(newObj).Elem()
+	t := s.Type
+	if t == nil {
+		t = oldVal.Type()
+	}
+	var changed ChangedFields
+	for _, field := range typeDiffFields(t) {
+		a := oldVal.FieldByIndex(field.index).Interface()
+		b := newVal.FieldByIndex(field.index).Interface()
+		if field.comparable {
+			if a != b {
+				changed = append(changed, field.name)
+			}
+		} else if !reflect.DeepEqual(a, b) {
+			changed = append(changed, field.name)
+		}
+	}
+	return changed
+}
+
+func (s *ObjectFactory) checkWatchFields(p ICommitTrigger) {
+	if ft, ok := p.(*FieldCommitTrigger); ok && s.Type != nil {
+		for _, name := range ft.fields {
+			if _, ok := s.Type.FieldByName(name); !ok {
+				formatndPanic("表[%s]添加提交触发器失败: 列[%s]不存在", s.Name, name)
+			}
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package gmemdb
 
+import (
+	"math/big"
+	"time"
+)
+
 type MdbFinder struct {
 	idx *MemIndex
 	err error
@@ -77,9 +82,76 @@ func (s MdbFinder) AppendFloat64(val float64) MdbFinder {
 	}
 	return s
 }
+func (s MdbFinder) AppendBool(val bool) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendBool(val)
+	}
+	return s
+}
+func (s MdbFinder) AppendInt8(val int8) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendInt8(val)
+	}
+	return s
+}
+func (s MdbFinder) AppendUInt8(val uint8) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendUInt8(val)
+	}
+	return s
+}
+func (s MdbFinder) AppendTime(val time.Time) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendTime(val)
+	}
+	return s
+}
+func (s MdbFinder) AppendDuration(val time.Duration) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendDuration(val)
+	}
+	return s
+}
+func (s MdbFinder) AppendBigInt(val *big.Int) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendBigInt(val)
+	}
+	return s
+}
+func (s MdbFinder) AppendDecimal(val string, scale int) MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendDecimal(val, scale)
+	}
+	return s
+}
+func (s MdbFinder) AppendNull() MdbFinder {
+	if s.err == nil {
+		s.err = s.idx.mdbKey.AppendNull()
+	}
+	return s
+}
 func (s MdbFinder) Fire() Iterator {
 	if s.err != nil {
 		return &radixIterator{atEnd: true}
 	}
 	return s.idx.findByKey(&s.idx.mdbKey, true)
 }
+
+// FireInto 使用可重复使用的迭代器查找,避免每次查找分配迭代器
+func (s MdbFinder) FireInto(cursor *Cursor) Iterator {
+	if s.err != nil {
+		cursor.value = nil
+		cursor.atEnd = true
+		return cursor
+	}
+	s.idx.seek(&cursor.radixIterator, &s.idx.mdbKey)
+	return cursor
+}
+
+// FindOne 按唯一索引的完整key查找对象,不创建迭代器
+func (s MdbFinder) FindOne() IObject {
+	if s.err != nil {
+		return nil
+	}
+	return s.idx.FindOne(&s.idx.mdbKey)
+}
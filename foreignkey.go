@@ -0,0 +1,282 @@
+package gmemdb
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// FKAction 父表记录删除时外键的处理方式
+type FKAction int
+
+const (
+	// FKRestrict 存在子表记录时禁止删除父表记录
+	FKRestrict FKAction = iota
+	// FKCascade 删除父表记录时级联删除子表记录
+	FKCascade
+	// FKSetNull 删除父表记录时将子表记录的外键字段置为零值
+	FKSetNull
+)
+
+func (s FKAction) String() string {
+	switch s {
+	case FKRestrict:
+		return "restrict"
+	case FKCascade:
+		return "cascade"
+	case FKSetNull:
+		return "set-null"
+	}
+	return "unknown"
+}
+
+// ForeignKey 外键,子表索引的key引用父表主索引或唯一索引的key,
+// 外键字段全为零值的子表记录视为未引用任何父表记录
+type ForeignKey struct {
+	child     *ObjectFactory
+	childIdx  *MemIndex
+	parent    *ObjectFactory
+	parentIdx *MemIndex
+	onDelete  FKAction
+	key       MdbKey
+	key1      MdbKey
+}
+
+// ForeignKeyError 外键约束失败
+type ForeignKeyError struct {
+	FK     *ForeignKey
+	Object IObject
+	Reason string
+}
+
+func (e *ForeignKeyError) Error() string {
+	return fmt.Sprintf("外键[%s]约束失败: %s", e.FK.Name(), e.Reason)
+}
+
+// AddForeignKey 在子表索引childIdxNum上添加外键,引用父表parent的parentIdxNum索引,
+// 父表索引必须是唯一索引且字段数量和子表索引一致
+func (s *ObjectFactory) AddForeignKey(childIdxNum int, parent *ObjectFactory, parentIdxNum int, onDelete FKAction) *ForeignKey {
+	childIdx := s.GetIndex(childIdxNum)
+	parentIdx := parent.GetIndex(parentIdxNum)
+	if childIdx == nil || parentIdx == nil {
+		formatndPanic("表[%s]添加外键失败: 索引不存在", s.Name)
+	}
+	if childIdx.multi || parentIdx.multi {
+		formatndPanic("表[%s]添加外键失败: 不支持多值索引", s.Name)
+	}
+	if childIdx.spatial != nil || parentIdx.spatial != nil {
+		formatndPanic("表[%s]添加外键失败: 不支持空间索引", s.Name)
+	}
+	if !parentIdx.mdbKey.IsUnique() {
+		formatndPanic("表[%s]添加外键失败: 父表[%s]索引[%s]不是唯一索引", s.Name, parent.Name, parentIdx.name)
+	}
+	if childIdx.mdbKey.KeyCount() != parentIdx.mdbKey.KeyCount() {
+		formatndPanic("表[%s]添加外键失败: 索引[%s]和父表[%s]索引[%s]字段数量不一致", s.Name, childIdx.name, parent.Name, parentIdx.name)
+	}
+	if childIdx.mdbKey.IsLegacy() != parentIdx.mdbKey.IsLegacy() {
+		formatndPanic("表[%s]添加外键失败: 索引[%s]和父表[%s]索引[%s]编码不一致", s.Name, childIdx.name, parent.Name, parentIdx.name)
+	}
+	for i := 0; i < childIdx.mdbKey.KeyCount(); i++ {
+		if childIdx.mdbKey.isDesc(i) != parentIdx.mdbKey.isDesc(i) {
+			formatndPanic("表[%s]添加外键失败: 索引[%s]和父表[%s]索引[%s]字段排序不一致", s.Name, childIdx.name, parent.Name, parentIdx.name)
+		}
+		if !sameKeyFuncs(&childIdx.mdbKey, &parentIdx.mdbKey, i) {
+			formatndPanic("表[%s]添加外键失败: 索引[%s]和父表[%s]索引[%s]字段变换或排序规则不一致", s.Name, childIdx.name, parent.Name, parentIdx.name)
+		}
+	}
+	fk := &ForeignKey{
+		child:     s,
+		childIdx:  childIdx,
+		parent:    parent,
+		parentIdx: parentIdx,
+		onDelete:  onDelete,
+	}
+	fk.key.Init(childIdx.mdbKey.KeyCount(), childIdx.mdbKey.IsUnique())
+	fk.key1.Init(childIdx.mdbKey.KeyCount(), childIdx.mdbKey.IsUnique())
+	s.foreignKeys = append(s.foreignKeys, fk)
+	parent.referencedBy = append(parent.referencedBy, fk)
+	return fk
+}
+
+// sameKeyFuncs 第i个子键的变换及排序规则是否一致,函数按代码比较,
+// 同一构造函数使用不同参数生成的闭包(如Truncate(60)和Truncate(3600))无法区分
+func sameKeyFuncs(a *MdbKey, b *MdbKey, i int) bool {
+	var ta, tb KeyTransform
+	if i < len(a.transforms) {
+		ta = a.transforms[i]
+	}
+	if i < len(b.transforms) {
+		tb = b.transforms[i]
+	}
+	var ca, cb Collation
+	if i < len(a.collations) {
+		ca = a.collations[i]
+	}
+	if i < len(b.collations) {
+		cb = b.collations[i]
+	}
+	return sameFunc(reflect.ValueOf(ta), reflect.ValueOf(tb)) && sameFunc(reflect.ValueOf(ca), reflect.ValueOf(cb))
+}
+
+func sameFunc(a reflect.Value, b reflect.Value) bool {
+	if a.IsNil() || b.IsNil() {
+		return a.IsNil() == b.IsNil()
+	}
+	return a.Pointer() == b.Pointer()
+}
+
+// Name 外键名字
+func (s *ForeignKey) Name() string {
+	return fmt.Sprintf("%s.%s->%s.%s", s.child.Name, s.childIdx.name, s.parent.Name, s.parentIdx.name)
+}
+
+// OnDelete 父表记录删除时的处理方式
+func (s *ForeignKey) OnDelete() FKAction {
+	return s.onDelete
+}
+
+func (s *ForeignKey) isNull(obj IObject) bool {
+	val := reflect.ValueOf(obj).Elem()
+	for _, field := range s.childIdx.fields {
+		if !val.FieldByIndex(field.Index).IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// checkParent 检查子表记录引用的父表记录是否存在
+func (s *ForeignKey) checkParent(obj IObject) error {
+	if s.isNull(obj) {
+		return nil
+	}
+	s.key.Reset()
+	if err := s.childIdx.makeKeyInto(&s.key, obj); err != nil {
+		return err
+	}
+	if s.key.HasNull() {
+		// 包含NULL的外键不引用父表记录
+		return nil
+	}
+	if _, ok := s.parentIdx.txn.Get(s.key.Key()); !ok {
+		return &ForeignKeyError{FK: s, Object: obj, Reason: fmt.Sprintf("父表[%s]不存在对应记录", s.parent.Name)}
+	}
+	return nil
+}
+
+// children 返回引用父表记录obj的所有子表记录
+func (s *ForeignKey) children(obj IObject) ([]IObject, error) {
+	s.key.Reset()
+	if err := s.parentIdx.makeKeyInto(&s.key, obj); err != nil {
+		return nil, err
+	}
+	var children []IObject
+	for it := s.childIdx.findByKey(&s.key, true); it.Next(); {
+		children = append(children, it.Value())
+	}
+	return children, nil
+}
+
+// keyChanged 父表记录更新时被引用的key是否变化
+func (s *ForeignKey) keyChanged(oldObj IObject, newObj IObject) (bool, error) {
+	s.key.Reset()
+	if err := s.parentIdx.makeKeyInto(&s.key, oldObj); err != nil {
+		return false, err
+	}
+	s.key1.Reset()
+	if err := s.parentIdx.makeKeyInto(&s.key1, newObj); err != nil {
+		return false, err
+	}
+	return string(s.key.Key()) != string(s.key1.Key()), nil
+}
+
+// setNull 复制子表记录并将外键字段置为零值
+func (s *ForeignKey) setNull(obj IObject) IObject {
+	newObj := copyObject(obj)
+	newVal := reflect.ValueOf(newObj).Elem()
+	for _, field := range s.childIdx.fields {
+		f := newVal.FieldByIndex(field.Index)
+		f.Set(reflect.Zero(f.Type()))
+	}
+	return newObj
+}
+
+// checkReferences 子表记录新增或更新时检查父表记录
+func (s *ObjectFactory) checkReferences(oldObj IObject, newObj IObject) error {
+	for _, fk := range s.foreignKeys {
+		if oldObj != nil {
+			fk.key1.Reset()
+			if err := fk.childIdx.makeKeyInto(&fk.key1, oldObj); err != nil {
+				return err
+			}
+			fk.key.Reset()
+			if err := fk.childIdx.makeKeyInto(&fk.key, newObj); err != nil {
+				return err
+			}
+			if string(fk.key.Key()) == string(fk.key1.Key()) {
+				continue
+			}
+		}
+		if err := fk.checkParent(newObj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyReferences 父表记录删除(newObj为nil)或更新时按外键定义处理子表记录,
+// 级联修改前在事物中分配回滚点,返回错误时已执行的级联修改回滚到该回滚点,成功时释放
+func (s *ObjectFactory) applyReferences(oldObj IObject, newObj IObject, transaction *Transaction, reason int32) error {
+	var savePoint *TransactionSavePoint
+	for _, fk := range s.referencedBy {
+		if newObj != nil {
+			changed, err := fk.keyChanged(oldObj, newObj)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+		}
+		children, err := fk.children(oldObj)
+		if err != nil {
+			return err
+		}
+		if len(children) == 0 {
+			continue
+		}
+		action := fk.onDelete
+		if newObj != nil && action == FKCascade {
+			// 只级联删除,父表key变化时按restrict处理
+			action = FKRestrict
+		}
+		if action != FKRestrict && savePoint == nil {
+			savePoint = transaction.allocSavePoint()
+		}
+		switch action {
+		case FKRestrict:
+			err = &ForeignKeyError{FK: fk, Object: oldObj, Reason: fmt.Sprintf("子表[%s]存在%d条引用记录", fk.child.Name, len(children))}
+		case FKCascade:
+			for _, child := range children {
+				if err = fk.child.internalRemove(child, transaction, reason, true); err != nil {
+					break
+				}
+			}
+		case FKSetNull:
+			for _, child := range children {
+				if err = fk.child.internalUpdate(child, fk.setNull(child), transaction, reason, true); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			if savePoint != nil {
+				savePoint.Rollback()
+			}
+			return err
+		}
+	}
+	if savePoint != nil {
+		transaction.releaseSavePoint(savePoint)
+	}
+	return nil
+}
@@ -6,4 +6,5 @@ require (
 	github.com/gogo/protobuf v1.3.1
 	github.com/onsi/ginkgo v1.10.3
 	github.com/onsi/gomega v1.7.1
+	golang.org/x/text v0.3.8
 )
@@ -31,13 +31,37 @@ type MemIndex struct {
 	lastError  error
 	mdbKey     MdbKey
 	mdbKey1    MdbKey
+	multi      bool
+	filter     func(obj IObject) bool
+	tokenize   TokenizeFunc
+	spatial    *spatialInfo
 }
 
 // NewMemIndex 新建唯一索引
 func NewMemIndex(name string, idxNum int, makeKey MakeKeyFunc, unique bool, table IFactory) *MemIndex {
+	return NewMemIndexWithOptions(name, idxNum, makeKey, IndexOptions{Unique: unique}, table)
+}
+
+func isVarLenKind(typ reflect.Type, multi bool) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8 {
+		return true
+	}
+	if multi && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+		// 多值索引按元素生成key
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.String
+}
+
+// NewMemIndexWithOptions 按选项新建索引
+func NewMemIndexWithOptions(name string, idxNum int, makeKey MakeKeyFunc, opts IndexOptions, table IFactory) *MemIndex {
 	txn := iradix.NewTxn()
 	root := txn.Root()
-	fieldNames := strings.Split(name, "|")
+	// 索引名中#之后为标签,不参与字段匹配,用于在相同字段上建立多个索引
+	fieldNames := strings.Split(strings.SplitN(name, "#", 2)[0], "|")
 	fields := make([]reflect.StructField, 0, len(fieldNames))
 	notMatchFields := []string{}
 	Type := table.getType()
@@ -51,6 +75,46 @@ func NewMemIndex(name string, idxNum int, makeKey MakeKeyFunc, unique bool, tabl
 	if len(notMatchFields) > 0 {
 		formatndPanic("表[%s]添加索引[%s]失败: 列[%s]不匹配", table.name(), name, strings.Join(notMatchFields, ","))
 	}
+	fieldPos := func(field string, what string) int {
+		for i, fieldName := range fieldNames {
+			if fieldName == field {
+				return i
+			}
+		}
+		formatndPanic("表[%s]添加索引[%s]失败: %s的列[%s]不在索引中", table.name(), name, what, field)
+		return -1
+	}
+	var transforms []KeyTransform
+	if len(opts.Transforms) > 0 {
+		transforms = make([]KeyTransform, len(fieldNames))
+		for field, t := range opts.Transforms {
+			transforms[fieldPos(field, "变换")] = t
+		}
+	}
+	var collations []Collation
+	if len(opts.Collations) > 0 {
+		collations = make([]Collation, len(fieldNames))
+		for field, c := range opts.Collations {
+			collations[fieldPos(field, "排序规则")] = c
+		}
+	}
+	var desc []bool
+	if len(opts.Descending) > 0 {
+		desc = make([]bool, len(fieldNames))
+		for _, field := range opts.Descending {
+			desc[fieldPos(field, "倒序")] = true
+		}
+		if len(fields) == 1 && desc[0] && transforms == nil && isVarLenKind(fields[0].Type, opts.MultiKey != nil) {
+			// 单值key没有子键头,变长的值取反后不能保证倒序
+			formatndPanic("表[%s]添加索引[%s]失败: 单字段的字符串或字节索引不支持倒序", table.name(), name)
+		}
+	}
+	if opts.MultiKey != nil {
+		if opts.Unique {
+			formatndPanic("表[%s]添加索引[%s]失败: 多值索引不能是唯一索引", table.name(), name)
+		}
+		makeKey = opts.MultiKey
+	}
 	idx := &MemIndex{
 		name:       []byte(name),
 		fieldNames: fieldNames,
@@ -59,10 +123,20 @@ func NewMemIndex(name string, idxNum int, makeKey MakeKeyFunc, unique bool, tabl
 		txn:        txn,
 		makeKey:    makeKey,
 		idxNum:     idxNum,
+		multi:      opts.MultiKey != nil,
+		filter:     opts.Filter,
 	}
 	keyCount := len(fields)
-	idx.mdbKey.Init(keyCount, unique)
-	idx.mdbKey1.Init(keyCount, unique)
+	idx.mdbKey.Init(keyCount, opts.Unique)
+	idx.mdbKey1.Init(keyCount, opts.Unique)
+	idx.mdbKey.SetTransforms(transforms)
+	idx.mdbKey1.SetTransforms(transforms)
+	idx.mdbKey.SetDescending(desc)
+	idx.mdbKey1.SetDescending(desc)
+	idx.mdbKey.SetLegacy(opts.LegacyKey)
+	idx.mdbKey1.SetLegacy(opts.LegacyKey)
+	idx.mdbKey.SetCollations(collations)
+	idx.mdbKey1.SetCollations(collations)
 	return idx
 }
 
@@ -76,8 +150,29 @@ func (s *MemIndex) FieldNames() []string {
 	return s.fieldNames
 }
 
+// IsMultiKey 是否多值索引
+func (s *MemIndex) IsMultiKey() bool {
+	return s.multi
+}
+
+// IsPartial 是否部分索引,部分索引只包含满足过滤条件的对象
+func (s *MemIndex) IsPartial() bool {
+	return s.filter != nil
+}
+
+// Contains 对象是否在索引范围内
+func (s *MemIndex) Contains(val IObject) bool {
+	return s.filter == nil || s.filter(val)
+}
+
 // Find 查找对象
 func (s *MemIndex) Find(val IObject) Iterator {
+	if s.multi {
+		formatndPanic("多值索引[%s]不支持按对象查找", s.name)
+	}
+	if s.spatial != nil {
+		formatndPanic("空间索引[%s]不支持按对象查找", s.name)
+	}
 	err := s.makeKeyWithUnique(&s.mdbKey, val)
 	if err != nil {
 		formatndPanic(err.Error())
@@ -107,6 +202,9 @@ func (s *MemIndex) FreeListLen() int {
 
 // FindByPB 根据proto对象查找
 func (s *MemIndex) FindByPB(pb interface{}) (Iterator, error) {
+	if s.spatial != nil {
+		return nil, fmt.Errorf("空间索引不支持按proto对象查找")
+	}
 	pbType := reflect.TypeOf(pb).Elem()
 	// 内存表第一个字段都是PrimaryID,而pb中没有这个字段
 	for _, field := range s.fields {
@@ -116,33 +214,53 @@ func (s *MemIndex) FindByPB(pb interface{}) (Iterator, error) {
 		}
 	}
 	pbValue := reflect.ValueOf(pb).Elem()
-	s.mdbKey.Reset()
+	// 指针字段为nil表示NULL,末尾的nil字段不参与查找(前缀查找)
+	last := -1
 	for i, field := range s.fields {
 		pbVal := pbValue.Field(field.Index[0] - 1)
+		if pbVal.Kind() != reflect.Ptr || !pbVal.IsNil() {
+			last = i
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("索引字段全部为nil")
+	}
+	s.mdbKey.Reset()
+	for _, field := range s.fields[:last+1] {
+		pbVal := pbValue.Field(field.Index[0] - 1)
+		var err error
 		if pbVal.Kind() == reflect.Ptr {
 			if pbVal.IsNil() {
-				if i == 0 {
-					// 第一个key必须不为0
-					return nil, fmt.Errorf("第一个索引必须不为nil")
-				}
-				break
+				err = s.mdbKey.AppendNull()
 			} else {
-				s.mdbKey.AppendValue(pbVal.Elem().Interface())
+				err = s.mdbKey.AppendValue(pbVal.Elem().Interface())
 			}
 		} else {
-			s.mdbKey.AppendValue(pbVal.Interface())
+			err = s.mdbKey.AppendValue(pbVal.Interface())
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 	return s.FindByKey(&s.mdbKey), nil
 }
 
-// FindByKey 指定key查找对象
+// FindByKey 指定key查找对象,空间索引使用Within、Nearby查找
 func (s *MemIndex) FindByKey(key *MdbKey) Iterator {
+	if s.spatial != nil {
+		formatndPanic("空间索引[%s]不支持按key查找", s.name)
+	}
 	return s.findByKey(key, true)
 }
 
 // Add 添加对象
 func (s *MemIndex) Add(val IObject) error {
+	if !s.Contains(val) {
+		return nil
+	}
+	if s.multi {
+		return s.addMulti(val)
+	}
 	err := s.makeKeyWithUnique(&s.mdbKey, val)
 	if err != nil {
 		return err
@@ -150,7 +268,7 @@ func (s *MemIndex) Add(val IObject) error {
 	key := s.mdbKey.Key()
 	_, didUpdate := s.txn.Insert(key, val)
 	if didUpdate {
-		return fmt.Errorf("索引冲突 %s", string(key))
+		return fmt.Errorf("索引冲突 %s", s.keyString(key))
 	}
 	s.root = s.txn.Root()
 	return nil
@@ -158,6 +276,21 @@ func (s *MemIndex) Add(val IObject) error {
 
 // Update 更新对象
 func (s *MemIndex) Update(oldVal IObject, newVal IObject) error {
+	if s.filter != nil {
+		in, out := s.filter(oldVal), s.filter(newVal)
+		if !in || !out {
+			// 对象移入或移出部分索引
+			if in {
+				return s.Delete(oldVal)
+			} else if out {
+				return s.Add(newVal)
+			}
+			return nil
+		}
+	}
+	if s.multi {
+		return s.updateMulti(oldVal, newVal)
+	}
 	err1 := s.makeKeyWithUnique(&s.mdbKey, oldVal)
 	if err1 != nil {
 		return err1
@@ -171,16 +304,16 @@ func (s *MemIndex) Update(oldVal IObject, newVal IObject) error {
 	if !bytes.Equal(oldKey, newKey) {
 		_, ok := s.txn.Delete(oldKey)
 		if !ok {
-			return fmt.Errorf("源索引不存在 %s", string(oldKey))
+			return fmt.Errorf("源索引不存在 %s", s.keyString(oldKey))
 		}
 		_, didUpdate := s.txn.Insert(newKey, newVal)
 		if didUpdate {
-			return fmt.Errorf("新索引冲突 %s", string(newKey))
+			return fmt.Errorf("新索引冲突 %s", s.keyString(newKey))
 		}
 	} else {
 		_, didUpdate := s.txn.Insert(oldKey, newVal)
 		if !didUpdate {
-			return fmt.Errorf("源索引不存在 %s", string(oldKey))
+			return fmt.Errorf("源索引不存在 %s", s.keyString(oldKey))
 		}
 	}
 	s.root = s.txn.Root()
@@ -189,6 +322,12 @@ func (s *MemIndex) Update(oldVal IObject, newVal IObject) error {
 
 // Delete 删除对象
 func (s *MemIndex) Delete(val IObject) error {
+	if !s.Contains(val) {
+		return nil
+	}
+	if s.multi {
+		return s.deleteMulti(val)
+	}
 	err := s.makeKeyWithUnique(&s.mdbKey, val)
 	if err != nil {
 		return err
@@ -211,50 +350,84 @@ func (s *MemIndex) Clear() {
 
 // Begin 返回第一个位置
 func (s *MemIndex) Begin() Iterator {
-	r := &radixIterator{
-		txn:           s.txn,
-		isCompoundKey: s.mdbKey.IsCompoundKey(),
-		isUnique:      s.mdbKey.IsUnique(),
-		prefixLen:     0,
-		atEnd:         false,
-		isSortGreat:   s.root.IsSortGreat(),
-	}
-	iter := s.root.InitRawIterator(&r.iter)
-	if !iter.SeekPrefix(s.root.Root(), nil) {
-		r.atEnd = true
-	}
+	r := &radixIterator{}
+	s.seek(r, nil)
 	return r
 }
 
 func (s *MemIndex) findByKey(key *MdbKey, skipNil bool) Iterator {
-	keyLen := key.Len()
-	r := &radixIterator{
-		txn:           s.txn,
-		isCompoundKey: key.IsCompoundKey(),
-		isUnique:      key.IsUnique(),
-		prefixLen:     keyLen,
-		atEnd:         false,
-		isSortGreat:   s.root.IsSortGreat(),
-		fieldCount:    key.KeyCount(),
-		keyFieldCount: key.KeyNum(),
+	r := &radixIterator{}
+	s.seek(r, key)
+	return r
+}
+
+// FindOne 在唯一索引中按完整的key查找对象,不创建迭代器;
+// 不是唯一索引、key不完整或包含NULL时返回nil
+func (s *MemIndex) FindOne(key *MdbKey) IObject {
+	if !key.IsUnique() || key.KeyNum() != key.KeyCount() || key.HasNull() {
+		return nil
+	}
+	if value, ok := s.txn.Get(key.Key()); ok {
+		return value.(IObject)
+	}
+	return nil
+}
+
+// seek 定位迭代器,key为nil时定位到第一个位置,复用迭代器已分配的内存
+func (s *MemIndex) seek(r *radixIterator, key *MdbKey) {
+	r.idx = s
+	r.txn = s.txn
+	r.key = nil
+	r.value = nil
+	r.atEnd = false
+	r.isSortGreat = s.root.IsSortGreat()
+	var prefix []byte
+	if key == nil {
+		r.isCompoundKey = s.mdbKey.IsCompoundKey()
+		r.isUnique = s.mdbKey.IsUnique()
+		r.prefixLen = 0
+		r.fieldCount = 0
+		r.keyFieldCount = 0
+		r.prefixNull = false
+	} else {
+		prefix = key.Key()
+		r.isCompoundKey = key.IsCompoundKey()
+		r.isUnique = key.IsUnique()
+		r.prefixLen = len(prefix)
+		r.fieldCount = key.KeyCount()
+		r.keyFieldCount = key.KeyNum()
+		r.prefixNull = key.HasNull()
 	}
 	iter := s.root.InitRawIterator(&r.iter)
-	if !iter.SeekPrefix(s.root.Root(), key.Key()) {
+	if !iter.SeekPrefix(s.root.Root(), prefix) {
 		r.atEnd = true
 	}
-	return r
+}
+
+// makeKeyInto 使用本索引的子键变换、排序、排序规则及编码生成其他MdbKey
+func (s *MemIndex) makeKeyInto(key *MdbKey, val IObject) error {
+	key.SetTransforms(s.mdbKey.transforms)
+	key.SetCollations(s.mdbKey.collations)
+	key.SetDescending(s.mdbKey.desc)
+	key.SetLegacy(s.mdbKey.legacy)
+	return s.makeKey(key, val)
 }
 
 func (s *MemIndex) makeKeyWithUnique(mdbKey *MdbKey, val IObject) error {
 	mdbKey.Reset()
 	if mdbKey.IsUnique() {
-		return s.makeKey(mdbKey, val)
+		if err := s.makeKey(mdbKey, val); err != nil || !mdbKey.HasNull() {
+			return err
+		}
+		// 包含NULL的key互不相等,唯一索引也要加上PrimaryID
+		return s.appendID(mdbKey, val.GetID())
 	}
 	id := val.GetID()
 	s.makeKey(mdbKey, val)
-	if mdbKey.Len() > 255 {
-		return fmt.Errorf("非唯一索引Key长度不允许超过255字节")
-	}
+	return s.appendID(mdbKey, id)
+}
+
+func (s *MemIndex) appendID(mdbKey *MdbKey, id uint32) error {
 	buf := mdbKey.Buffer()
 	if id != 0 {
 		if s.root.IsSortGreat() {
@@ -0,0 +1,696 @@
+package gmemdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jxlczjp77/gmemdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type dbPlayerObj struct {
+	gmemdb.ObjectBase
+	Name      string
+	Account   string
+	LoginTime int64
+	Level     int32
+	Tags      []string
+	Online    bool
+	Intro     string
+	X         float32
+	Y         float32
+}
+
+type dbPlayerObjPB struct {
+	Name      *string
+	Account   *string
+	LoginTime *int64
+	Level     *int32
+}
+
+type playerMDB struct {
+	gmemdb.ObjectFactory
+}
+
+func newPlayerMDB() *playerMDB {
+	db := &playerMDB{}
+	db.Init("playerMDB", (*dbPlayerObj)(nil), nil)
+	return db
+}
+
+func (s *playerMDB) add(name string, account string, loginTime int64, level int32) *dbPlayerObj {
+	obj := &dbPlayerObj{Name: name, Account: account, LoginTime: loginTime, Level: level}
+	Expect(s.TryAdd(obj, nil, 0)).Should(Succeed())
+	return obj
+}
+
+func playerNames(it gmemdb.Iterator) []string {
+	var names []string
+	for it.Next() {
+		names = append(names, it.Value().(*dbPlayerObj).Name)
+	}
+	return names
+}
+
+var _ = Describe("索引扩展测试", func() {
+	var mdb *playerMDB
+	BeforeEach(func() {
+		mdb = newPlayerMDB()
+	})
+
+	It("派生索引测试", func() {
+		mdb.AddIndexWithOptions("Name", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Name)
+		}, gmemdb.IndexOptions{Unique: true, Transforms: map[string]gmemdb.KeyTransform{"Name": gmemdb.LowerCase()}})
+		mdb.AddIndexWithOptions("LoginTime|Level", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			key.AppendInt64(obj.(*dbPlayerObj).LoginTime)
+			return key.AppendInt32(obj.(*dbPlayerObj).Level)
+		}, gmemdb.IndexOptions{Transforms: map[string]gmemdb.KeyTransform{"LoginTime": gmemdb.Truncate(86400)}})
+		mdb.AddIndexWithOptions("Account", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Account)
+		}, gmemdb.IndexOptions{Transforms: map[string]gmemdb.KeyTransform{"Account": gmemdb.Chain(gmemdb.LowerCase(), gmemdb.Hash())}})
+		Expect(func() {
+			mdb.AddIndexWithOptions("Level", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+				return key.AppendInt32(obj.(*dbPlayerObj).Level)
+			}, gmemdb.IndexOptions{Transforms: map[string]gmemdb.KeyTransform{"Name": gmemdb.LowerCase()}})
+		}).Should(Panic())
+
+		alice := mdb.add("Alice", "alice@example.com", 86400*2+100, 10)
+		mdb.add("Bob", "BOB@example.com", 86400*2+200, 20)
+		mdb.add("Carol", "carol@example.com", 86400*3+100, 10)
+
+		Expect(playerNames(mdb.FindByIndexName("Name").AppendString("ALICE").Fire())).Should(Equal([]string{"Alice"}))
+		Expect(playerNames(mdb.FindByIndexName("Name").AppendString("alice").Fire())).Should(Equal([]string{"Alice"}))
+		Expect(func() { mdb.Add(&dbPlayerObj{Name: "aLiCe"}, nil, 0) }).Should(Panic())
+
+		// 按天分桶
+		Expect(playerNames(mdb.FindByIndexName("LoginTime|Level").AppendInt64(86400*2 + 5000).Fire())).Should(Equal([]string{"Alice", "Bob"}))
+		Expect(playerNames(mdb.FindByIndexName("LoginTime|Level").AppendInt64(86400 * 2).AppendInt32(20).Fire())).Should(Equal([]string{"Bob"}))
+		Expect(playerNames(mdb.FindByIndexName("LoginTime|Level").AppendInt64(86400 * 3).Fire())).Should(Equal([]string{"Carol"}))
+
+		// 哈希
+		Expect(playerNames(mdb.FindByIndexName("Account").AppendString("bob@EXAMPLE.com").Fire())).Should(Equal([]string{"Bob"}))
+
+		// 更新后派生key同步变化
+		tmp := *alice
+		tmp.Name = "Alicia"
+		tmp.LoginTime = 86400 * 3
+		Expect(mdb.TryUpdate(alice, &tmp, nil, 0)).Should(Succeed())
+		Expect(playerNames(mdb.FindByIndexName("Name").AppendString("ALICE").Fire())).Should(BeEmpty())
+		Expect(playerNames(mdb.FindByIndexName("Name").AppendString("ALICIA").Fire())).Should(Equal([]string{"Alicia"}))
+		Expect(playerNames(mdb.FindByIndexName("LoginTime|Level").AppendInt64(86400*3 + 1).Fire())).Should(Equal([]string{"Alicia", "Carol"}))
+	})
+
+	It("Truncate负数取整", func() {
+		t := gmemdb.Truncate(10)
+		Expect(t(int64(-1))).Should(Equal(int64(-10)))
+		Expect(t(int32(15))).Should(Equal(int32(10)))
+		Expect(t(uint16(19))).Should(Equal(uint16(10)))
+		// 步长超出类型范围
+		Expect(gmemdb.Truncate(65536)(uint16(65535))).Should(Equal(uint16(0)))
+		Expect(gmemdb.Truncate(1 << 32)(uint32(7))).Should(Equal(uint32(0)))
+		Expect(gmemdb.Truncate(100000)(int16(-5))).Should(Equal(int16(math.MinInt16)))
+		Expect(gmemdb.Truncate(100000)(int16(5))).Should(Equal(int16(0)))
+		Expect(t(int8(-1))).Should(Equal(int8(-10)))
+		Expect(gmemdb.Truncate(200)(int8(-1))).Should(Equal(int8(math.MinInt8)))
+		Expect(t(uint8(19))).Should(Equal(uint8(10)))
+		Expect(gmemdb.Truncate(256)(uint8(255))).Should(Equal(uint8(0)))
+		Expect(t(int64(math.MinInt64))).Should(Equal(int64(math.MinInt64)))
+	})
+
+	It("多值索引测试", func() {
+		mdb.AddIndexWithOptions("Tags|Level", nil, gmemdb.IndexOptions{
+			MultiKey: func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+				p := obj.(*dbPlayerObj)
+				for _, tag := range p.Tags {
+					key.AppendString(tag)
+					key.AppendInt32(p.Level)
+					if err := key.Emit(); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Transforms: map[string]gmemdb.KeyTransform{"Tags": gmemdb.LowerCase()},
+		})
+		Expect(func() {
+			mdb.AddIndexWithOptions("Tags", nil, gmemdb.IndexOptions{Unique: true, MultiKey: func(key *gmemdb.MdbKey, obj gmemdb.IObject) error { return nil }})
+		}).Should(Panic())
+		byTag := func(tag string) []string {
+			return playerNames(mdb.FindByIndexName("Tags|Level").AppendString(tag).Fire())
+		}
+
+		alice := &dbPlayerObj{Name: "Alice", Level: 10, Tags: []string{"pvp", "guild", "PVP"}}
+		bob := &dbPlayerObj{Name: "Bob", Level: 20, Tags: []string{"guild"}}
+		mdb.Add(alice, nil, 0)
+		mdb.Add(bob, nil, 0)
+		mdb.Add(&dbPlayerObj{Name: "Carol", Level: 10}, nil, 0)
+		Expect(byTag("pvp")).Should(Equal([]string{"Alice"}))
+		Expect(byTag("guild")).Should(Equal([]string{"Alice", "Bob"}))
+		Expect(playerNames(mdb.FindByIndexName("Tags|Level").AppendString("guild").AppendInt32(20).Fire())).Should(Equal([]string{"Bob"}))
+		Expect(playerNames(mdb.GetIndexByName("Tags|Level").Begin())).Should(Equal([]string{"Alice", "Bob", "Alice"}))
+
+		// 更新时只修改变化的key
+		transaction := gmemdb.NewTransaction()
+		tmp := *alice
+		tmp.Tags = []string{"guild", "trade"}
+		Expect(mdb.TryUpdate(alice, &tmp, transaction, 0)).Should(Succeed())
+		Expect(byTag("pvp")).Should(BeEmpty())
+		Expect(byTag("trade")).Should(Equal([]string{"Alice"}))
+		Expect(byTag("guild")).Should(Equal([]string{"Alice", "Bob"}))
+		transaction.Rollback()
+		Expect(byTag("pvp")).Should(Equal([]string{"Alice"}))
+		Expect(byTag("trade")).Should(BeEmpty())
+
+		mdb.Remove(alice, nil, 0)
+		Expect(byTag("guild")).Should(Equal([]string{"Bob"}))
+		Expect(playerNames(mdb.GetIndexByName("Tags|Level").Begin())).Should(Equal([]string{"Bob"}))
+
+		// 普通索引的key函数不能调用Emit
+		var key gmemdb.MdbKey
+		key.Init(1, false)
+		key.AppendInt32(1)
+		Expect(key.Emit()).ShouldNot(Succeed())
+	})
+
+	It("部分索引测试", func() {
+		mdb.AddIndexWithOptions("Level", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendInt32(obj.(*dbPlayerObj).Level)
+		}, gmemdb.IndexOptions{Filter: func(obj gmemdb.IObject) bool { return obj.(*dbPlayerObj).Online }})
+		online := func() []string {
+			return playerNames(mdb.GetIndexByName("Level").Begin())
+		}
+
+		alice := &dbPlayerObj{Name: "Alice", Level: 10, Online: true}
+		bob := &dbPlayerObj{Name: "Bob", Level: 20}
+		mdb.Add(alice, nil, 0)
+		mdb.Add(bob, nil, 0)
+		Expect(online()).Should(Equal([]string{"Alice"}))
+		Expect(mdb.GetIndexByName("Level").Contains(bob)).Should(BeFalse())
+
+		// 上线、升级、下线
+		bob2 := *bob
+		bob2.Online = true
+		mdb.Update(bob, &bob2, nil, 0)
+		Expect(online()).Should(Equal([]string{"Alice", "Bob"}))
+		bob3 := bob2
+		bob3.Level = 5
+		mdb.Update(&bob2, &bob3, nil, 0)
+		Expect(online()).Should(Equal([]string{"Bob", "Alice"}))
+		alice2 := *alice
+		alice2.Online = false
+		alice2.Level = 11
+		mdb.Update(alice, &alice2, nil, 0)
+		Expect(online()).Should(Equal([]string{"Bob"}))
+		offline := alice2
+		offline.Level = 12
+		mdb.Update(&alice2, &offline, nil, 0)
+		Expect(online()).Should(Equal([]string{"Bob"}))
+		mdb.Remove(&bob3, nil, 0)
+		mdb.Remove(&offline, nil, 0)
+		Expect(online()).Should(BeEmpty())
+		Expect(mdb.Count()).Should(Equal(0))
+
+		// 按pb查找时需要显式使用部分索引
+		mdb.Add(&dbPlayerObj{Name: "Carol", Level: 30, Online: true}, nil, 0)
+		level := int32(30)
+		_, err := mdb.FindByPB(&dbPlayerObjPB{Level: &level})
+		Expect(err).Should(HaveOccurred())
+		it, err := mdb.FindByPBUsingPartial(&dbPlayerObjPB{Level: &level})
+		Expect(err).Should(BeNil())
+		Expect(playerNames(it)).Should(Equal([]string{"Carol"}))
+	})
+
+	It("全文索引测试", func() {
+		Expect(gmemdb.Tokenize("Hello, World! 公会战开始了 a")).Should(Equal([]string{"hello", "world", "公会", "会战", "战开", "开始", "始了", "a"}))
+		Expect(gmemdb.Tokenize("打 boss")).Should(Equal([]string{"打", "boss"}))
+
+		idx := mdb.GetIndex(mdb.AddTextIndex("Intro", nil))
+		Expect(func() { mdb.AddTextIndex("Intro", nil) }).Should(Panic())
+		Expect(func() { mdb.AddTextIndex("Level", nil) }).Should(Panic())
+		Expect(func() { mdb.GetIndex(0).MatchAll("x") }).Should(Panic())
+
+		alice := &dbPlayerObj{Name: "Alice", Intro: "Looking for a guild, 周末打公会战"}
+		mdb.Add(alice, nil, 0)
+		mdb.Add(&dbPlayerObj{Name: "Bob", Intro: "guild leader 招人"}, nil, 0)
+		mdb.Add(&dbPlayerObj{Name: "Carol", Intro: "trade only"}, nil, 0)
+
+		Expect(playerNames(idx.MatchAll("GUILD"))).Should(Equal([]string{"Alice", "Bob"}))
+		Expect(playerNames(idx.MatchAll("guild leader"))).Should(Equal([]string{"Bob"}))
+		Expect(playerNames(idx.MatchAll("公会战"))).Should(Equal([]string{"Alice"}))
+		Expect(playerNames(idx.MatchAll("guild trade"))).Should(BeEmpty())
+		Expect(playerNames(idx.MatchAny("leader trade"))).Should(Equal([]string{"Bob", "Carol"}))
+		Expect(playerNames(idx.MatchPrefix("Gui"))).Should(Equal([]string{"Alice", "Bob"}))
+		Expect(playerNames(idx.MatchPrefix("公"))).Should(Equal([]string{"Alice"}))
+		Expect(playerNames(idx.MatchPrefix("tr"))).Should(Equal([]string{"Carol"}))
+		Expect(playerNames(idx.MatchPrefix("Guild LE"))).Should(Equal([]string{"Bob"}))
+		Expect(playerNames(idx.MatchPrefix(", "))).Should(BeEmpty())
+
+		// 参与事物及回滚
+		transaction := gmemdb.NewTransaction()
+		tmp := *alice
+		tmp.Intro = "trade and craft"
+		mdb.Update(alice, &tmp, transaction, 0)
+		Expect(playerNames(idx.MatchAll("trade"))).Should(Equal([]string{"Alice", "Carol"}))
+		Expect(playerNames(idx.MatchAll("guild"))).Should(Equal([]string{"Bob"}))
+		transaction.Rollback()
+		Expect(playerNames(idx.MatchAll("trade"))).Should(Equal([]string{"Carol"}))
+		Expect(playerNames(idx.MatchAll("guild"))).Should(Equal([]string{"Alice", "Bob"}))
+	})
+
+	It("空间索引测试", func() {
+		idx := mdb.GetIndex(mdb.AddSpatialIndex("X", "Y", 10))
+		Expect(func() { mdb.AddSpatialIndex("X", "Y", 10) }).Should(Panic())
+		Expect(func() { mdb.AddSpatialIndex("Name", "Y", 10) }).Should(Panic())
+
+		alice := &dbPlayerObj{Name: "Alice", X: 1, Y: 1}
+		mdb.Add(alice, nil, 0)
+		mdb.Add(&dbPlayerObj{Name: "Bob", X: 12, Y: 3}, nil, 0)
+		mdb.Add(&dbPlayerObj{Name: "Carol", X: -5, Y: -9.5}, nil, 0)
+		mdb.Add(&dbPlayerObj{Name: "Dave", X: 100, Y: 100}, nil, 0)
+
+		Expect(playerNames(idx.Within(gmemdb.Rect{MinX: 0, MinY: 0, MaxX: 20, MaxY: 20}))).Should(Equal([]string{"Alice", "Bob"}))
+		Expect(playerNames(idx.Within(gmemdb.Rect{MinX: -10, MinY: -10, MaxX: 1, MaxY: 1}))).Should(Equal([]string{"Alice", "Carol"}))
+		Expect(playerNames(idx.Within(gmemdb.Rect{MinX: 2, MinY: 2, MaxX: 11, MaxY: 11}))).Should(BeEmpty())
+		Expect(playerNames(idx.Nearby(gmemdb.Point{X: 10, Y: 2}, 10))).Should(Equal([]string{"Bob", "Alice"}))
+		Expect(playerNames(idx.Nearby(gmemdb.Point{X: 0, Y: 0}, 13))).Should(Equal([]string{"Alice", "Carol", "Bob"}))
+		// 覆盖网格过多时遍历索引
+		Expect(playerNames(idx.Within(gmemdb.Rect{MinX: -1e6, MinY: -1e6, MaxX: 1e6, MaxY: 1e6}))).Should(Equal([]string{"Alice", "Bob", "Carol", "Dave"}))
+
+		// 移动后网格变化
+		tmp := *alice
+		tmp.X, tmp.Y = 95, 99
+		mdb.Update(alice, &tmp, nil, 0)
+		Expect(playerNames(idx.Nearby(gmemdb.Point{X: 0, Y: 0}, 13))).Should(Equal([]string{"Carol", "Bob"}))
+		Expect(playerNames(idx.Nearby(gmemdb.Point{X: 100, Y: 100}, 6))).Should(Equal([]string{"Dave", "Alice"}))
+		mdb.Remove(&tmp, nil, 0)
+		Expect(playerNames(idx.Nearby(gmemdb.Point{X: 100, Y: 100}, 6))).Should(Equal([]string{"Dave"}))
+
+		// 空间索引只能按区域查询,调试输出显示网格坐标
+		Expect(func() { idx.Find(&tmp) }).Should(Panic())
+		Expect(func() { idx.FindByKey(idx.DefaultKey()) }).Should(Panic())
+		var buf bytes.Buffer
+		Expect(idx.Dump(&buf)).Should(Succeed())
+		Expect(buf.String()).Should(ContainSubstring("(10, 10) -> "))
+		Expect(buf.String()).Should(ContainSubstring("(-1, -1) -> "))
+	})
+
+	It("过期删除测试", func() {
+		mdb.SetExpireField("LoginTime")
+		Expect(func() { mdb.SetExpireFunc(func(obj gmemdb.IObject) time.Time { return time.Time{} }) }).Should(Panic())
+		Expect(gmemdb.ReasonName(gmemdb.ReasonExpire)).Should(Equal("过期删除"))
+
+		var removed []string
+		var reasons []int32
+		mdb.AddCommitTrigger(gmemdb.MakeCommitTrigger(nil, nil, func(fid uint32, obj gmemdb.IObject, reason int32) {
+			removed = append(removed, obj.(*dbPlayerObj).Name)
+			reasons = append(reasons, reason)
+		}))
+
+		_, ok := mdb.NextExpire()
+		Expect(ok).Should(BeFalse())
+		mdb.add("永久", "", 0, 0)
+		bob := mdb.add("Bob", "", 200, 0)
+		mdb.add("Alice", "", 100, 0)
+		mdb.add("Carol", "", 300, 0)
+		next, ok := mdb.NextExpire()
+		Expect(ok).Should(BeTrue())
+		Expect(next).Should(Equal(time.Unix(100, 0)))
+
+		// 续期
+		tmp := *bob
+		tmp.LoginTime = 400
+		mdb.Update(bob, &tmp, nil, 0)
+
+		n, err := mdb.Expire(time.Unix(300, 0), nil)
+		Expect(err).Should(BeNil())
+		Expect(n).Should(Equal(2))
+		Expect(removed).Should(Equal([]string{"Alice", "Carol"}))
+		Expect(reasons).Should(Equal([]int32{gmemdb.ReasonExpire, gmemdb.ReasonExpire}))
+		Expect(mdb.Count()).Should(Equal(2))
+
+		// 在调用方事物中删除
+		transaction := gmemdb.NewTransaction()
+		n, err = mdb.Expire(time.Unix(1000, 0), transaction)
+		Expect(n).Should(Equal(1))
+		Expect(err).Should(BeNil())
+		transaction.Rollback()
+		Expect(mdb.Count()).Should(Equal(2))
+
+		// 内部事物中任一删除失败则整体回滚
+		vetoBob := true
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil, nil, nil, nil,
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) bool {
+				return !vetoBob || obj.(*dbPlayerObj).Name != "Bob"
+			}))
+		mdb.add("Eve", "", 350, 0)
+		n, err = mdb.Expire(time.Unix(1000, 0), nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(n).Should(BeZero())
+		Expect(mdb.Count()).Should(Equal(3))
+		Expect(removed).Should(Equal([]string{"Alice", "Carol"}))
+		vetoBob = false
+
+		// 定时删除,删除操作投递到调用方执行
+		posted := make(chan func(), 1)
+		sweeper := mdb.StartExpireSweeper(time.Millisecond, func(fn func()) {
+			select {
+			case posted <- fn:
+			default:
+			}
+		})
+		(<-posted)()
+		sweeper.Stop()
+		sweeper.Stop()
+		Expect(removed).Should(Equal([]string{"Alice", "Carol", "Eve", "Bob"}))
+		Expect(func() { mdb.StartExpireSweeper(time.Millisecond, nil) }).Should(Panic())
+		Expect(playerNames(mdb.GetIndex(0).Begin())).Should(Equal([]string{"永久"}))
+	})
+
+	It("NULL子键测试", func() {
+		var null, empty gmemdb.MdbKey
+		null.Init(1, true)
+		empty.Init(1, true)
+		Expect(null.AppendNull()).Should(Succeed())
+		Expect(empty.AppendString("")).Should(Succeed())
+		Expect(null.HasNull()).Should(BeTrue())
+		Expect(string(null.Key()) < string(empty.Key())).Should(BeTrue())
+		Expect(null.AppendNull()).ShouldNot(Succeed())
+
+		// 空账号作为NULL,唯一索引允许多个NULL
+		accountKey := func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			if account := obj.(*dbPlayerObj).Account; account != "" {
+				return key.AppendString(account)
+			}
+			return key.AppendNull()
+		}
+		idx := mdb.GetIndex(mdb.AddIndex("Account", accountKey, true))
+		mdb.AddIndex("Account|Level", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			if err := accountKey(key, obj); err != nil {
+				return err
+			}
+			return key.AppendInt32(obj.(*dbPlayerObj).Level)
+		}, true)
+		mdb.add("Alice", "a", 0, 1)
+		bob := mdb.add("Bob", "", 0, 2)
+		mdb.add("Carol", "", 0, 2)
+		Expect(playerNames(idx.Begin())).Should(Equal([]string{"Bob", "Carol", "Alice"}))
+		Expect(playerNames(mdb.FindByIndexName("Account").AppendNull().Fire())).Should(Equal([]string{"Bob", "Carol"}))
+		Expect(playerNames(mdb.FindByIndexName("Account").AppendString("a").Fire())).Should(Equal([]string{"Alice"}))
+		Expect(playerNames(idx.Find(bob))).Should(Equal([]string{"Bob"}))
+
+		// pb中为nil的字段作为NULL查找,末尾的nil字段按前缀查找
+		level := int32(2)
+		it, err := mdb.GetIndexByName("Account|Level").FindByPB(&dbPlayerObjPB{Level: &level})
+		Expect(err).Should(BeNil())
+		Expect(playerNames(it)).Should(Equal([]string{"Bob", "Carol"}))
+		account := "a"
+		it, err = mdb.GetIndexByName("Account|Level").FindByPB(&dbPlayerObjPB{Account: &account})
+		Expect(err).Should(BeNil())
+		Expect(playerNames(it)).Should(Equal([]string{"Alice"}))
+		_, err = mdb.GetIndexByName("Account|Level").FindByPB(&dbPlayerObjPB{})
+		Expect(err).ShouldNot(BeNil())
+
+		// 非NULL的值仍然唯一
+		Expect(func() { mdb.add("Dave", "a", 0, 1) }).Should(Panic())
+	})
+
+	It("更多键类型及倒序测试", func() {
+		sorted := func(vals ...interface{}) bool {
+			var prev string
+			for i, v := range vals {
+				var key gmemdb.MdbKey
+				key.Init(1, true)
+				Expect(key.AppendValue(v)).Should(Succeed())
+				if i > 0 && prev >= string(key.Key()) {
+					return false
+				}
+				prev = string(key.Key())
+			}
+			return true
+		}
+		Expect(sorted(nil, false, true)).Should(BeTrue())
+		Expect(sorted(int8(-128), int8(-1), int8(0), int8(127))).Should(BeTrue())
+		Expect(sorted(uint8(0), uint8(1), uint8(255))).Should(BeTrue())
+		Expect(sorted(time.Unix(-10, 5), time.Unix(-10, 6), time.Unix(0, 0), time.Unix(1e10, 0))).Should(BeTrue())
+		Expect(sorted(-time.Hour, time.Duration(0), time.Second)).Should(BeTrue())
+		huge := new(big.Int).Lsh(big.NewInt(1), 100)
+		Expect(sorted(new(big.Int).Neg(huge), big.NewInt(-256), big.NewInt(-1), big.NewInt(0), big.NewInt(1), big.NewInt(256), huge)).Should(BeTrue())
+
+		// 组合key中大整数按值排序,不受绝对值长度影响
+		composite := func(v *big.Int, n int32) string {
+			var key gmemdb.MdbKey
+			key.Init(2, true)
+			Expect(key.AppendBigInt(v)).Should(Succeed())
+			Expect(key.AppendInt32(n)).Should(Succeed())
+			return string(key.Key())
+		}
+		bigs := []*big.Int{new(big.Int).Neg(huge), big.NewInt(-1000), big.NewInt(-256), big.NewInt(-1), big.NewInt(0),
+			big.NewInt(1), big.NewInt(256), big.NewInt(1000), huge}
+		for i := 1; i < len(bigs); i++ {
+			Expect(composite(bigs[i-1], 100) < composite(bigs[i], -100)).Should(BeTrue(), bigs[i].String())
+		}
+
+		decimal := func(val string) string {
+			var key gmemdb.MdbKey
+			key.Init(1, true)
+			Expect(key.AppendDecimal(val, 2)).Should(Succeed())
+			return string(key.Key())
+		}
+		Expect(decimal("-1.5") < decimal("-0.25")).Should(BeTrue())
+		Expect(decimal("-0.25") < decimal("0")).Should(BeTrue())
+		Expect(decimal("0.1") < decimal("2")).Should(BeTrue())
+		Expect(decimal("2") < decimal("10.05")).Should(BeTrue())
+		Expect(decimal("1.230")).Should(Equal(decimal("+1.23")))
+		var key gmemdb.MdbKey
+		key.Init(1, true)
+		Expect(key.AppendDecimal("1.234", 2)).ShouldNot(Succeed())
+		Expect(key.AppendDecimal("1.2.3", 2)).ShouldNot(Succeed())
+		Expect(key.AppendDecimal("-", 2)).ShouldNot(Succeed())
+
+		// 等级升序,同等级按登录时间倒序
+		Expect(func() {
+			mdb.AddIndexWithOptions("Level", nil, gmemdb.IndexOptions{Descending: []string{"Name"}})
+		}).Should(Panic())
+		Expect(func() {
+			mdb.AddIndexWithOptions("Name#desc", nil, gmemdb.IndexOptions{Descending: []string{"Name"}})
+		}).Should(Panic())
+		idxNum := mdb.AddIndexWithOptions("Level|LoginTime", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			key.AppendInt32(obj.(*dbPlayerObj).Level)
+			return key.AppendInt64(obj.(*dbPlayerObj).LoginTime)
+		}, gmemdb.IndexOptions{Descending: []string{"LoginTime"}})
+		mdb.add("A", "", 100, 1)
+		mdb.add("B", "", 300, 1)
+		mdb.add("C", "", 200, 2)
+		mdb.add("D", "", 200, 1)
+		mdb.add("E", "", -100, 1)
+		Expect(playerNames(mdb.Begin(idxNum))).Should(Equal([]string{"B", "D", "A", "E", "C"}))
+		Expect(playerNames(mdb.FindByIndex(idxNum).AppendInt32(1).Fire())).Should(Equal([]string{"B", "D", "A", "E"}))
+		Expect(playerNames(mdb.FindByIndex(idxNum).AppendInt32(1).AppendInt64(200).Fire())).Should(Equal([]string{"D"}))
+	})
+
+	It("键解码测试", func() {
+		nameIdx := mdb.GetIndex(mdb.AddIndex("Name", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Name)
+		}, true))
+		levelIdx := mdb.GetIndex(mdb.AddIndexWithOptions("Level|LoginTime|Online", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			key.AppendInt32(obj.(*dbPlayerObj).Level)
+			if obj.(*dbPlayerObj).LoginTime == 0 {
+				key.AppendNull()
+			} else {
+				key.AppendInt64(obj.(*dbPlayerObj).LoginTime)
+			}
+			return key.AppendBool(obj.(*dbPlayerObj).Online)
+		}, gmemdb.IndexOptions{Descending: []string{"Level"}}))
+		tagIdx := mdb.GetIndex(mdb.AddIndexWithOptions("Tags", nil, gmemdb.IndexOptions{
+			MultiKey: func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+				for _, tag := range obj.(*dbPlayerObj).Tags {
+					key.AppendString(tag)
+					if err := key.Emit(); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		}))
+		hashIdx := mdb.GetIndex(mdb.AddIndexWithOptions("Account", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Account)
+		}, gmemdb.IndexOptions{Transforms: map[string]gmemdb.KeyTransform{"Account": gmemdb.Hash()}}))
+
+		alice := &dbPlayerObj{Name: "Alice", Account: "a", Level: 3, Online: true, Tags: []string{"pvp"}}
+		Expect(mdb.TryAdd(alice, nil, 0)).Should(Succeed())
+		mdb.add("Bob", "b", 100, -1)
+
+		it := nameIdx.Begin()
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(BeNil())
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(Equal([]interface{}{"Alice"}))
+		it = levelIdx.Begin()
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(Equal([]interface{}{int32(3), nil, true}))
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(Equal([]interface{}{int32(-1), int64(100), false}))
+		it = tagIdx.Begin()
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(Equal([]interface{}{"pvp"}))
+		// 变换后的子键无法还原
+		it = hashIdx.Begin()
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()[0]).Should(BeAssignableToTypeOf([]byte(nil)))
+
+		_, err := nameIdx.DecodeKey([]byte{9})
+		Expect(err).ShouldNot(BeNil())
+
+		var buf bytes.Buffer
+		Expect(levelIdx.Dump(&buf)).Should(Succeed())
+		Expect(buf.String()).Should(Equal(fmt.Sprintf("(3, NULL, true) -> %d\n(-1, 100, false) -> %d\n", alice.GetID(), alice.GetID()+1)))
+
+		// 错误信息中显示解码后的key
+		msg := func() (msg string) {
+			defer func() { msg = fmt.Sprint(recover()) }()
+			mdb.add("Alice", "c", 0, 0)
+			return
+		}()
+		Expect(msg).Should(ContainSubstring(`Name("Alice")`))
+	})
+
+	It("组合键字符串排序及迁移测试", func() {
+		nameLevel := func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			key.AppendString(obj.(*dbPlayerObj).Name)
+			return key.AppendInt32(obj.(*dbPlayerObj).Level)
+		}
+		legacy := mdb.AddIndexWithOptions("Name|Level#legacy", nameLevel, gmemdb.IndexOptions{LegacyKey: true})
+		natural := mdb.AddIndexWithOptions("Name|Level", nameLevel, gmemdb.IndexOptions{Unique: true})
+		desc := mdb.AddIndexWithOptions("Name|Level#desc", nameLevel, gmemdb.IndexOptions{Descending: []string{"Name"}})
+		for _, name := range []string{"bob", "al", "alice", "al\x00x", "b"} {
+			mdb.add(name, "", 0, 1)
+		}
+		Expect(playerNames(mdb.Begin(natural))).Should(Equal([]string{"al", "al\x00x", "alice", "b", "bob"}))
+		Expect(playerNames(mdb.Begin(desc))).Should(Equal([]string{"bob", "b", "alice", "al\x00x", "al"}))
+		Expect(playerNames(mdb.Begin(legacy))).Should(Equal([]string{"b", "al", "bob", "al\x00x", "alice"}))
+		Expect(playerNames(mdb.FindByIndex(natural).AppendString("al").Fire())).Should(Equal([]string{"al"}))
+		Expect(playerNames(mdb.FindByIndex(desc).AppendString("al\x00x").Fire())).Should(Equal([]string{"al\x00x"}))
+		it := mdb.FindByIndex(desc).AppendString("al\x00x").AppendInt32(1).Fire()
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(Equal([]interface{}{"al\x00x", int32(1)}))
+
+		// 超过255字节的字符串不再受长度字节限制
+		long := strings.Repeat("x", 300)
+		mdb.add(long, "", 0, 1)
+		Expect(playerNames(mdb.FindByIndex(natural).AppendString(long).Fire())).Should(Equal([]string{long}))
+		Expect(mdb.FindByIndex(legacy).AppendString(long).Fire().Step()).Should(BeNil())
+		Expect(mdb.Remove(mdb.FindByIndex(natural).AppendString(long).Fire().Step(), nil, 0)).Should(BeTrue())
+
+		// 旧编码迁移到新编码
+		Expect(mdb.MigrateIndex(legacy)).Should(Succeed())
+		Expect(mdb.GetIndex(legacy).DefaultKey().IsLegacy()).Should(BeFalse())
+		Expect(mdb.MigrateIndex(0)).ShouldNot(Succeed())
+		Expect(mdb.MigrateIndex(100)).ShouldNot(Succeed())
+		Expect(playerNames(mdb.Begin(legacy))).Should(Equal([]string{"al", "al\x00x", "alice", "b", "bob"}))
+
+		// 有数据后添加的索引需要重建
+		account := mdb.AddIndex("Account", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Name)
+		}, true)
+		Expect(mdb.Begin(account).Step()).Should(BeNil())
+		transaction := gmemdb.NewTransaction()
+		mdb.add("carol", "", 0, 1)
+		Expect(mdb.TryAdd(&dbPlayerObj{Name: "dave"}, transaction, 0)).Should(Succeed())
+		Expect(mdb.RebuildIndex(account)).ShouldNot(Succeed())
+		transaction.Rollback()
+		Expect(mdb.RebuildIndex(account)).Should(Succeed())
+		Expect(playerNames(mdb.Begin(account))).Should(Equal([]string{"al", "al\x00x", "alice", "b", "bob", "carol"}))
+		Expect(mdb.RebuildIndex(0)).ShouldNot(Succeed())
+	})
+
+	It("排序规则测试", func() {
+		pinyin := map[string]string{"张三": "zhang san", "李四": "li si", "王五": "wang wu"}
+		name := func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Name)
+		}
+		byPinyin := mdb.AddIndexWithOptions("Name", name, gmemdb.IndexOptions{
+			Unique: true,
+			Collations: map[string]gmemdb.Collation{"Name": gmemdb.LocaleCollation(func(s string) []byte {
+				return []byte(pinyin[s])
+			})},
+		})
+		byCodePoint := mdb.AddIndexWithOptions("Name#binary", name, gmemdb.IndexOptions{
+			Collations: map[string]gmemdb.Collation{"Name": gmemdb.BinaryCollation()},
+		})
+		Expect(func() {
+			mdb.AddIndexWithOptions("Level", nil, gmemdb.IndexOptions{Collations: map[string]gmemdb.Collation{"Name": gmemdb.NFCCollation()}})
+		}).Should(Panic())
+		mdb.AddIndexWithOptions("Account|Level", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			key.AppendString(obj.(*dbPlayerObj).Account)
+			return key.AppendInt32(obj.(*dbPlayerObj).Level)
+		}, gmemdb.IndexOptions{Unique: true, Collations: map[string]gmemdb.Collation{"Account": gmemdb.FoldCollation()}})
+		mdb.AddIndexWithOptions("Intro", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Intro)
+		}, gmemdb.IndexOptions{Collations: map[string]gmemdb.Collation{"Intro": gmemdb.NFCCollation()}})
+
+		mdb.add("张三", "Alice", 0, 1)
+		mdb.add("李四", "Bob", 0, 1)
+		Expect(mdb.TryAdd(&dbPlayerObj{Name: "王五", Account: "carol", Intro: "caf\u00e9"}, nil, 0)).Should(Succeed())
+		Expect(playerNames(mdb.Begin(byPinyin))).Should(Equal([]string{"李四", "王五", "张三"}))
+		Expect(playerNames(mdb.Begin(byCodePoint))).Should(Equal([]string{"张三", "李四", "王五"}))
+		Expect(playerNames(mdb.FindByIndex(byPinyin).AppendString("张三").Fire())).Should(Equal([]string{"张三"}))
+
+		// 不区分大小写
+		Expect(playerNames(mdb.FindByIndexName("Account|Level").AppendString("ALICE").Fire())).Should(Equal([]string{"张三"}))
+		Expect(func() { mdb.add("赵六", "alice", 0, 1) }).Should(Panic())
+
+		// 组合字符和预组合字符相等
+		Expect(playerNames(mdb.FindByIndexName("Intro").AppendString("cafe\u0301").Fire())).Should(Equal([]string{"王五"}))
+
+		// 使用排序规则的子键不能还原
+		it := mdb.Begin(byPinyin)
+		Expect(it.Next()).Should(BeTrue())
+		Expect(it.(gmemdb.KeyIterator).Key()).Should(Equal([]interface{}{[]byte("li si")}))
+	})
+
+	It("FindOne及可重复使用迭代器测试", func() {
+		byName := mdb.AddIndex("Name", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendString(obj.(*dbPlayerObj).Name)
+		}, true)
+		byLevel := mdb.AddIndex("Level", func(key *gmemdb.MdbKey, obj gmemdb.IObject) error {
+			return key.AppendInt32(obj.(*dbPlayerObj).Level)
+		}, false)
+		alice := mdb.add("Alice", "", 0, 1)
+		bob := mdb.add("Bob", "", 0, 1)
+		mdb.add("Carol", "", 0, 2)
+
+		Expect(mdb.FindByIndex(byName).AppendString("Bob").FindOne()).Should(BeIdenticalTo(bob))
+		Expect(mdb.FindByIndex(byName).AppendString("Dave").FindOne()).Should(BeNil())
+		Expect(mdb.FindByIndex(byLevel).AppendInt32(1).FindOne()).Should(BeNil())
+		Expect(mdb.FindOneByPrimaryID(alice.GetID())).Should(BeIdenticalTo(alice))
+
+		// 事物中未提交的修改也能查到
+		transaction := gmemdb.NewTransaction()
+		dave := &dbPlayerObj{Name: "Dave"}
+		Expect(mdb.TryAdd(dave, transaction, 0)).Should(Succeed())
+		Expect(mdb.FindByIndex(byName).AppendString("Dave").FindOne()).Should(BeIdenticalTo(dave))
+		transaction.Rollback()
+		Expect(mdb.FindByIndex(byName).AppendString("Dave").FindOne()).Should(BeNil())
+
+		var cursor gmemdb.Cursor
+		Expect(playerNames(mdb.FindByIndex(byLevel).AppendInt32(1).FireInto(&cursor))).Should(Equal([]string{"Alice", "Bob"}))
+		Expect(playerNames(mdb.FindByIndex(byLevel).AppendInt32(2).FireInto(&cursor))).Should(Equal([]string{"Carol"}))
+		Expect(playerNames(mdb.FindByIndex(byLevel).AppendInt32(3).FireInto(&cursor))).Should(BeEmpty())
+		Expect(playerNames(cursor.Begin(mdb.GetIndex(byName)))).Should(Equal([]string{"Alice", "Bob", "Carol"}))
+		Expect(playerNames(mdb.FindByIndex(byName).AppendString("Bob").AppendInt32(1).FireInto(&cursor))).Should(BeEmpty())
+
+		// 热路径查找不分配内存
+		Expect(testing.AllocsPerRun(100, func() {
+			mdb.FindByIndex(byName).AppendString("Bob").FindOne()
+		})).Should(BeZero())
+		Expect(testing.AllocsPerRun(100, func() {
+			mdb.FindByIndex(byLevel).AppendInt32(1).FireInto(&cursor).Step()
+		})).Should(BeZero())
+	})
+})
@@ -46,6 +46,14 @@ func (s *RawIterator) init() {
 	s.key.Reset()
 }
 func (s *RawIterator) newStack() *tStack {
+	// 重复使用时复用之前分配的节点数组
+	if n := len(s.stack); n < cap(s.stack) {
+		s.stack = s.stack[:n+1]
+		stack := &s.stack[n]
+		stack.nodes = stack.nodes[:0]
+		stack.pos = -1
+		return stack
+	}
 	s.stack = append(s.stack, tStack{pos: -1})
 	return &s.stack[len(s.stack)-1]
 }
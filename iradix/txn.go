@@ -287,6 +287,30 @@ func (t *Txn) AllocSavePoint() {
 	}
 }
 
+// ReleaseSavePoint 释放最后一个事物回滚点,其中的修改并入上一个回滚点
+func (t *Txn) ReleaseSavePoint() {
+	n := len(t.savePoints)
+	if n == 0 {
+		return
+	}
+	sp := &t.savePoints[n-1]
+	prev := &t.defSavePoint
+	if n > 1 {
+		prev = &t.savePoints[n-2]
+	}
+	// 新节点改为上一个回滚点的版本,之后在上一个回滚点中可以直接修改
+	node := sp.txNewNodes.Front()
+	for i := 0; i < sp.txNewNodes.Len(); i++ {
+		node.version = prev.version
+		node = node.next
+	}
+	prev.txNewNodes.PushBackList(&sp.txNewNodes)
+	prev.txTmpNodes.PushBackList(&sp.txTmpNodes)
+	prev.txOldNodes.PushBackList(&sp.txOldNodes)
+	t.savePoints = t.savePoints[:n-1]
+	t.currentSP = prev
+}
+
 // Rollback 回滚事物
 func (t *Txn) Rollback() {
 	t.RollbackTo(-1)
@@ -19,7 +19,18 @@ type Iterator interface {
 	UnLockDB()
 }
 
+// KeyIterator 可以获取当前key的迭代器,Begin及MdbFinder.Fire返回的迭代器实现了该接口,
+// 全文索引和空间索引的查询结果不对应单个key,没有实现
+type KeyIterator interface {
+	Iterator
+
+	// Key 当前对象在索引中的key,按索引字段类型解码
+	Key() []interface{}
+}
+
 type radixIterator struct {
+	idx           *MemIndex
+	key           []byte
 	txn           *iradix.Txn
 	iter          iradix.RawIterator
 	prefixLen     int
@@ -30,6 +41,19 @@ type radixIterator struct {
 	atEnd         bool
 	value         IObject
 	isSortGreat   bool
+	prefixNull    bool
+}
+
+// Cursor 可重复使用的迭代器,高频查找时用MdbFinder.FireInto或Begin重新定位,
+// 重新定位后之前的迭代结果失效
+type Cursor struct {
+	radixIterator
+}
+
+// Begin 定位到索引的第一个位置
+func (c *Cursor) Begin(idx *MemIndex) Iterator {
+	idx.seek(&c.radixIterator, nil)
+	return c
 }
 
 func (r *radixIterator) LockDB() {
@@ -41,8 +65,9 @@ func (r *radixIterator) UnLockDB() {
 }
 
 func (r *radixIterator) RawNext() bool {
-	_, value, ok := r.iter.RawNext()
+	key, value, ok := r.iter.RawNext()
 	if ok {
+		r.key = key
 		r.value = value.(IObject)
 		return true
 	}
@@ -65,6 +90,17 @@ func (r *radixIterator) Value() IObject {
 	return r.value
 }
 
+func (r *radixIterator) Key() []interface{} {
+	if r.value == nil || r.idx == nil {
+		return nil
+	}
+	vals, err := r.idx.DecodeKey(r.key)
+	if err != nil {
+		return nil
+	}
+	return vals
+}
+
 func (r *radixIterator) Step() IObject {
 	if r.Next() {
 		return r.Value()
@@ -82,6 +118,7 @@ func (r *radixIterator) RawStep() IObject {
 func (r *radixIterator) doNext() IObject {
 	key, value, ok := r.iter.Next()
 	if ok {
+		r.key = key
 		obj := value.(IObject)
 		n := len(key)
 		if n == r.prefixLen || r.prefixLen == 0 {
@@ -96,12 +133,7 @@ func (r *radixIterator) doNext() IObject {
 					// 非组合key必须精确匹配长度
 					return nil
 				}
-				id := binary.BigEndian.Uint32(key[n-4:])
-				if r.isSortGreat {
-					// 索引从大到小排列时,id也要倒序一下,不然后插入的记录会排在先插入记录的前面
-					id = math.MaxUint32 - id
-				}
-				if obj.GetID() != id {
+				if !r.matchID(obj, key[n-4:]) {
 					return nil
 				}
 				if !r.isCompoundKey {
@@ -111,18 +143,36 @@ func (r *radixIterator) doNext() IObject {
 				key = key[0:n]
 			}
 			if r.isCompoundKey {
-				fieldCount := 0
+				hasNull := r.prefixNull
 				i := prefixLen
-				for i < n {
-					subKeyLen := uint8(key[i])
-					i += int(subKeyLen) + 1
-					fieldCount++
+				for field := r.keyFieldCount; field < r.fieldCount; field++ {
+					end, null, ok := r.idx.mdbKey.subKeyEnd(key[:n], i, field)
+					if !ok {
+						return nil
+					}
+					hasNull = hasNull || null
+					i = end
+				}
+				if i == n {
+					return obj
 				}
-				if i == n && fieldCount+r.keyFieldCount == r.fieldCount {
+				// 唯一索引中包含NULL的key后面有PrimaryID
+				if r.isUnique && hasNull && i+4 == n && r.matchID(obj, key[i:]) {
 					return obj
 				}
+			} else if r.isUnique && key[0] == keyTagNull && prefixLen+4 == n && r.matchID(obj, key[prefixLen:]) {
+				return obj
 			}
 		}
 	}
 	return nil
 }
+
+func (r *radixIterator) matchID(obj IObject, b []byte) bool {
+	id := binary.BigEndian.Uint32(b)
+	if r.isSortGreat {
+		// 索引从大到小排列时,id也要倒序一下,不然后插入的记录会排在先插入记录的前面
+		id = math.MaxUint32 - id
+	}
+	return obj.GetID() == id
+}
@@ -3,7 +3,10 @@ package gmemdb
 import (
 	"fmt"
 	"math"
+	"math/big"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/jxlczjp77/gmemdb/iradix"
 )
@@ -52,8 +55,17 @@ type MdbKey struct {
 	isUnique bool
 	keyCount int
 
-	keyNum  int
-	tailNil bool
+	keyNum     int
+	hasNull    bool
+	desc       []bool
+	valueStart int
+	legacy     bool
+	collations []Collation
+	collated   bool
+
+	transforms []KeyTransform
+	raw        bool
+	onEmit     func(key *MdbKey) error
 }
 
 func (s *MdbKey) Init(keyCount int, isUnique bool) {
@@ -86,7 +98,12 @@ func (s *MdbKey) KeyNum() int {
 func (s *MdbKey) Reset() {
 	s.buf.Reset()
 	s.keyNum = 0
-	s.tailNil = false
+	s.hasNull = false
+}
+
+// HasNull 是否包含NULL子键
+func (s *MdbKey) HasNull() bool {
+	return s.hasNull
 }
 func (s *MdbKey) Key() []byte {
 	return s.buf.Bytes()
@@ -98,63 +115,320 @@ func (s *MdbKey) Buffer() *iradix.ByteBuffer {
 	return &s.buf
 }
 func (s *MdbKey) AppendBytes(val []byte) error {
-	s.writeHead(len(val))
-	return s.buf.Write(val)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if s.escaped() {
+		if err := s.writeHead(escapedSubKey); err != nil {
+			return err
+		}
+		for _, c := range val {
+			s.writeEscaped(c)
+		}
+		s.buf.Write(subKeyTerminator)
+		return s.endValue()
+	}
+	if err := s.writeHead(len(val)); err != nil {
+		return err
+	}
+	s.buf.Write(val)
+	return s.endValue()
 }
 func (s *MdbKey) AppendString(val string) error {
-	s.writeHead(len(val))
-	return s.buf.WriteString(val)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if c := s.collation(); c != nil {
+		return s.appendCollated(c, val)
+	}
+	if s.escaped() {
+		if err := s.writeHead(escapedSubKey); err != nil {
+			return err
+		}
+		for i := 0; i < len(val); i++ {
+			s.writeEscaped(val[i])
+		}
+		s.buf.Write(subKeyTerminator)
+		return s.endValue()
+	}
+	if err := s.writeHead(len(val)); err != nil {
+		return err
+	}
+	s.buf.WriteString(val)
+	return s.endValue()
 }
 
 func (s *MdbKey) AppendInt16(val int16) error {
-	s.writeHead(3)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(3); err != nil {
+		return err
+	}
 	if val >= 0 {
 		s.buf.WriteByte('>')
 	} else {
 		s.buf.WriteByte('-')
 	}
-	return s.buf.WriteUInt16(uint16(val))
+	s.buf.WriteUInt16(uint16(val))
+	return s.endValue()
 }
 func (s *MdbKey) AppendInt32(val int32) error {
-	s.writeHead(5)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(5); err != nil {
+		return err
+	}
 	if val >= 0 {
 		s.buf.WriteByte('>')
 	} else {
 		s.buf.WriteByte('-')
 	}
-	return s.buf.WriteUInt32(uint32(val))
+	s.buf.WriteUInt32(uint32(val))
+	return s.endValue()
 }
 func (s *MdbKey) AppendInt64(val int64) error {
-	s.writeHead(9)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(9); err != nil {
+		return err
+	}
 	if val >= 0 {
 		s.buf.WriteByte('>')
 	} else {
 		s.buf.WriteByte('-')
 	}
-	return s.buf.WriteUInt64(uint64(val))
+	s.buf.WriteUInt64(uint64(val))
+	return s.endValue()
 }
 func (s *MdbKey) AppendInt(val int) error   { return s.AppendInt32(int32(val)) }
 func (s *MdbKey) AppendUInt(val uint) error { return s.AppendUInt32(uint32(val)) }
 func (s *MdbKey) AppendUInt16(val uint16) error {
-	s.writeHead(2)
-	return s.buf.WriteUInt16(val)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(2); err != nil {
+		return err
+	}
+	s.buf.WriteUInt16(val)
+	return s.endValue()
 }
 func (s *MdbKey) AppendUInt32(val uint32) error {
-	s.writeHead(4)
-	return s.buf.WriteUInt32(val)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(4); err != nil {
+		return err
+	}
+	s.buf.WriteUInt32(val)
+	return s.endValue()
 }
 func (s *MdbKey) AppendUInt64(val uint64) error {
-	s.writeHead(8)
-	return s.buf.WriteUInt64(val)
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(8); err != nil {
+		return err
+	}
+	s.buf.WriteUInt64(val)
+	return s.endValue()
+}
+func (s *MdbKey) AppendInt8(val int8) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(2); err != nil {
+		return err
+	}
+	if val >= 0 {
+		s.buf.WriteByte('>')
+	} else {
+		s.buf.WriteByte('-')
+	}
+	s.buf.WriteByte(byte(val))
+	return s.endValue()
+}
+func (s *MdbKey) AppendUInt8(val uint8) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(1); err != nil {
+		return err
+	}
+	s.buf.WriteByte(val)
+	return s.endValue()
+}
+
+// AppendBool false排在true之前
+func (s *MdbKey) AppendBool(val bool) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(1); err != nil {
+		return err
+	}
+	if val {
+		s.buf.WriteByte(1)
+	} else {
+		s.buf.WriteByte(0)
+	}
+	return s.endValue()
+}
+
+// AppendTime 按时间先后排序,精确到纳秒,不保存时区
+func (s *MdbKey) AppendTime(val time.Time) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if err := s.writeHead(13); err != nil {
+		return err
+	}
+	sec := val.Unix()
+	if sec >= 0 {
+		s.buf.WriteByte('>')
+	} else {
+		s.buf.WriteByte('-')
+	}
+	s.buf.WriteUInt64(uint64(sec))
+	s.buf.WriteUInt32(uint32(val.Nanosecond()))
+	return s.endValue()
+}
+func (s *MdbKey) AppendDuration(val time.Duration) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	return s.AppendInt64(int64(val))
+}
+
+// AppendBigInt 任意精度整数,绝对值最多255字节,旧编码的组合key中受子键长度限制最多252字节,为nil时添加NULL
+func (s *MdbKey) AppendBigInt(val *big.Int) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
+	if val == nil {
+		return s.AppendNull()
+	}
+	// 符号+绝对值长度+绝对值,负数的长度和绝对值取反,绝对值越大越靠前
+	mag := val.Bytes()
+	if len(mag) > 255 {
+		return fmt.Errorf("大整数绝对值不允许超过255字节")
+	}
+	data := make([]byte, 0, len(mag)+2)
+	switch val.Sign() {
+	case 0:
+		data = append(data, '=', 0)
+	case 1:
+		data = append(data, '>', byte(len(mag)))
+		data = append(data, mag...)
+	default:
+		data = append(data, '-', byte(255-len(mag)))
+		for _, b := range mag {
+			data = append(data, ^b)
+		}
+	}
+	if s.escaped() {
+		// 组合key中按长度的子键头会让长度不同的值按长度排序,使用转义编码
+		if err := s.writeHead(escapedSubKey); err != nil {
+			return err
+		}
+		for _, c := range data {
+			s.writeEscaped(c)
+		}
+		s.buf.Write(subKeyTerminator)
+		return s.endValue()
+	}
+	if err := s.writeHead(len(data)); err != nil {
+		return err
+	}
+	s.buf.Write(data)
+	return s.endValue()
+}
+
+// AppendDecimal 定点小数,如"12.5"按scale=2编码为整数1250,同一子键必须使用相同的scale
+func (s *MdbKey) AppendDecimal(val string, scale int) error {
+	n, err := parseDecimal(val, scale)
+	if err != nil {
+		return err
+	}
+	return s.AppendBigInt(n)
+}
+
+func parseDecimal(val string, scale int) (*big.Int, error) {
+	if scale < 0 {
+		return nil, fmt.Errorf("无效的小数位数%d", scale)
+	}
+	digits := val
+	neg := false
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		neg = digits[0] == '-'
+		digits = digits[1:]
+	}
+	intPart, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, frac = digits[:i], digits[i+1:]
+	}
+	if len(frac) > scale {
+		if strings.TrimRight(frac[scale:], "0") != "" {
+			return nil, fmt.Errorf("小数[%s]超过%d位小数", val, scale)
+		}
+		frac = frac[:scale]
+	}
+	digits = intPart + frac + strings.Repeat("0", scale-len(frac))
+	if intPart+frac == "" || strings.Trim(digits, "0123456789") != "" {
+		return nil, fmt.Errorf("无效的小数[%s]", val)
+	}
+	n, _ := new(big.Int).SetString(digits, 10)
+	if neg {
+		n.Neg(n)
+	}
+	return n, nil
 }
+
 func (s *MdbKey) AppendFloat32(val float32) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
 	return s.AppendUInt32(float32ToUint32(val))
 }
 func (s *MdbKey) AppendFloat64(val float64) error {
+	if t := s.transform(); t != nil {
+		return s.appendTransformed(t, val)
+	}
 	return s.AppendUInt64(float64ToUint64(val))
 }
+
+// AppendNull 添加NULL子键,NULL排在所有值之前;唯一索引允许多个包含NULL的key
+func (s *MdbKey) AppendNull() error {
+	if s.keyNum >= s.keyCount {
+		return fmt.Errorf("超出给定Key数量[%d]", s.keyCount)
+	}
+	if s.keyCount > 1 && s.keyNum == 0 {
+		s.buf.WriteByte(byte(s.keyCount))
+	}
+	s.buf.WriteByte(keyTagNull)
+	s.keyNum++
+	s.hasNull = true
+	return nil
+}
+
 func (s *MdbKey) AppendValue(val interface{}) error {
 	switch t := val.(type) {
+	case nil:
+		return s.AppendNull()
+	case bool:
+		return s.AppendBool(val.(bool))
+	case int8:
+		return s.AppendInt8(val.(int8))
+	case uint8:
+		return s.AppendUInt8(val.(uint8))
+	case time.Time:
+		return s.AppendTime(val.(time.Time))
+	case time.Duration:
+		return s.AppendDuration(val.(time.Duration))
+	case *big.Int:
+		return s.AppendBigInt(val.(*big.Int))
 	case int16:
 		return s.AppendInt16(val.(int16))
 	case int32:
@@ -171,6 +445,10 @@ func (s *MdbKey) AppendValue(val interface{}) error {
 		return s.AppendUInt(val.(uint))
 	case uint64:
 		return s.AppendUInt64(val.(uint64))
+	case float32:
+		return s.AppendFloat32(val.(float32))
+	case float64:
+		return s.AppendFloat64(val.(float64))
 	case string:
 		return s.AppendString(val.(string))
 	case []byte:
@@ -186,16 +464,186 @@ func (s *MdbKey) AppendValue(val interface{}) error {
 	}
 }
 
+// Emit 多值索引的key函数中每生成完一个key调用一次,然后继续生成下一个key,
+// 最后一个key可以不调用
+func (s *MdbKey) Emit() error {
+	if s.onEmit == nil {
+		return fmt.Errorf("只有多值索引的key函数可以调用Emit")
+	}
+	err := s.onEmit(s)
+	s.Reset()
+	return err
+}
+
+// SetTransforms 设置每个子键的变换,为nil的子键不变换
+func (s *MdbKey) SetTransforms(transforms []KeyTransform) {
+	for _, t := range transforms {
+		if t != nil {
+			s.transforms = transforms
+			return
+		}
+	}
+	s.transforms = nil
+}
+
+// SetDescending 设置每个子键是否倒序,倒序的子键编码后按位取反;
+// NULL仍然排在最前,单值key中变长的字符串和字节只保证不互为前缀的值倒序,
+// 索引不允许单字段的字符串或字节倒序
+func (s *MdbKey) SetDescending(desc []bool) {
+	for _, d := range desc {
+		if d {
+			s.desc = desc
+			return
+		}
+	}
+	s.desc = nil
+}
+
+func (s *MdbKey) isDesc(n int) bool {
+	return n < len(s.desc) && s.desc[n]
+}
+
+func (s *MdbKey) endValue() error {
+	if s.isDesc(s.keyNum - 1) {
+		b := s.buf.Bytes()
+		for i := s.valueStart; i < len(b); i++ {
+			b[i] = ^b[i]
+		}
+	}
+	return nil
+}
+
+// SetCollations 设置每个字符串子键的排序规则,为nil的子键按字节排序
+func (s *MdbKey) SetCollations(collations []Collation) {
+	for _, c := range collations {
+		if c != nil {
+			s.collations = collations
+			return
+		}
+	}
+	s.collations = nil
+}
+
+func (s *MdbKey) collation() Collation {
+	if s.collated || s.keyNum >= len(s.collations) {
+		return nil
+	}
+	return s.collations[s.keyNum]
+}
+
+func (s *MdbKey) appendCollated(c Collation, val string) error {
+	s.collated = true
+	defer func() { s.collated = false }()
+	return s.AppendBytes(c(val))
+}
+
+func (s *MdbKey) transform() KeyTransform {
+	if s.raw || s.keyNum >= len(s.transforms) {
+		return nil
+	}
+	return s.transforms[s.keyNum]
+}
+
+func (s *MdbKey) appendTransformed(t KeyTransform, val interface{}) error {
+	s.raw = true
+	defer func() { s.raw = false }()
+	return s.AppendValue(t(val))
+}
+
+// 子键头: 单字段key为标记字节,组合key为[子键数量]加每个子键的[长度+1],
+// 值为0表示NULL,后面没有数据;组合key中的字符串和字节子键头为0xFF,
+// 数据中的0转义为0x00 0xFF,以0x00 0x01结尾,按字典序排列
+const (
+	keyTagNull         = 0x00
+	keyTagValue        = 0x01
+	keyHeadEscaped     = 0xFF
+	escapedSubKey      = -1
+	maxSubKeyLen       = 253
+	maxLegacySubKeyLen = 254
+)
+
+var subKeyTerminator = []byte{0x00, 0x01}
+
+func (s *MdbKey) escaped() bool {
+	return s.keyCount > 1 && !s.legacy
+}
+
+func (s *MdbKey) writeEscaped(c byte) {
+	s.buf.WriteByte(c)
+	if c == 0 {
+		s.buf.WriteByte(0xFF)
+	}
+}
+
+// SetLegacy 组合key使用旧编码,字符串和字节子键按长度排序,用于兼容依赖旧顺序的代码
+func (s *MdbKey) SetLegacy(legacy bool) {
+	s.legacy = legacy
+}
+
+// IsLegacy 是否使用旧的组合key编码
+func (s *MdbKey) IsLegacy() bool {
+	return s.legacy
+}
+
 func (s *MdbKey) writeHead(n int) error {
-	if s.keyNum > s.keyCount {
+	if s.keyNum >= s.keyCount {
 		return fmt.Errorf("超出给定Key数量[%d]", s.keyCount)
 	}
 	if s.keyCount > 1 {
+		maxLen := maxSubKeyLen
+		if s.legacy {
+			maxLen = maxLegacySubKeyLen
+		}
+		if n > maxLen {
+			return fmt.Errorf("组合键子项长度不允许超过%d字节", maxLen)
+		}
 		if s.keyNum == 0 {
 			s.buf.WriteByte(byte(s.keyCount))
 		}
-		s.buf.WriteByte(byte(n))
+		if n == escapedSubKey {
+			s.buf.WriteByte(keyHeadEscaped)
+		} else {
+			s.buf.WriteByte(byte(n + 1))
+		}
+	} else {
+		s.buf.WriteByte(keyTagValue)
 	}
 	s.keyNum++
+	s.valueStart = s.buf.Len()
 	return nil
 }
+
+// singleValueKey 单字段key的编码,用于直接遍历索引
+func singleValueKey(data []byte) []byte {
+	return append([]byte{keyTagValue}, data...)
+}
+
+// subKeyEnd 组合key中从i开始的第field个子键的结束位置
+func (s *MdbKey) subKeyEnd(key []byte, i int, field int) (end int, null bool, ok bool) {
+	if i >= len(key) {
+		return 0, false, false
+	}
+	h := key[i]
+	switch {
+	case h == keyTagNull:
+		return i + 1, true, true
+	case h == keyHeadEscaped && !s.legacy:
+		var x byte
+		if s.isDesc(field) {
+			x = 0xFF
+		}
+		for j := i + 1; j+1 < len(key); j++ {
+			if key[j]^x != 0 {
+				continue
+			}
+			if key[j+1]^x == 1 {
+				return j + 2, false, true
+			}
+			// 转义的0
+			j++
+		}
+		return 0, false, false
+	}
+	end = i + int(h)
+	return end, false, end <= len(key)
+}
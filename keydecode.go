@@ -0,0 +1,259 @@
+package gmemdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jxlczjp77/gmemdb/iradix"
+)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// DecodeKey 按索引字段类型把编码后的key还原为字段值,NULL还原为nil,
+// 无法确定类型的子键(经过变换、排序规则、自定义编码等)还原为[]byte,
+// 空间索引还原为坐标所在的网格
+func (s *MemIndex) DecodeKey(key []byte) ([]interface{}, error) {
+	subKeys, err := s.splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.spatial != nil {
+		return s.spatial.decodeCell(subKeys[0]), nil
+	}
+	vals := make([]interface{}, len(subKeys))
+	for i, sub := range subKeys {
+		if sub == nil {
+			continue
+		}
+		vals[i] = decodeValue(s.subKeyType(i), sub)
+	}
+	return vals, nil
+}
+
+// subKeyType 子键对应的字段类型,无法确定时返回nil
+func (s *MemIndex) subKeyType(i int) reflect.Type {
+	if len(s.fields) != s.mdbKey.KeyCount() || (i < len(s.mdbKey.transforms) && s.mdbKey.transforms[i] != nil) ||
+		(i < len(s.mdbKey.collations) && s.mdbKey.collations[i] != nil) {
+		return nil
+	}
+	typ := s.fields[i].Type
+	if s.multi && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
+		// 多值索引按元素生成key
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// splitKey 把key拆分为子键,NULL子键为nil,去掉末尾的PrimaryID,倒序及转义的子键还原为原始编码
+func (s *MemIndex) splitKey(key []byte) ([][]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key为空")
+	}
+	keyCount := s.mdbKey.KeyCount()
+	if keyCount == 1 {
+		switch key[0] {
+		case keyTagNull:
+			return [][]byte{nil}, nil
+		case keyTagValue:
+			data := key[1:]
+			if !s.mdbKey.IsUnique() {
+				if len(data) < 4 {
+					return nil, fmt.Errorf("key缺少PrimaryID")
+				}
+				data = data[:len(data)-4]
+			}
+			return [][]byte{s.ascending(data, 0)}, nil
+		}
+		return nil, fmt.Errorf("无效的key标记[%d]", key[0])
+	}
+	if int(key[0]) != keyCount {
+		return nil, fmt.Errorf("子键数量[%d]和索引字段数量[%d]不一致", key[0], keyCount)
+	}
+	subKeys := make([][]byte, 0, keyCount)
+	i := 1
+	for field := 0; field < keyCount; field++ {
+		end, null, ok := s.mdbKey.subKeyEnd(key, i, field)
+		if !ok {
+			return nil, fmt.Errorf("key不完整")
+		}
+		switch {
+		case null:
+			subKeys = append(subKeys, nil)
+		case key[i] == keyHeadEscaped && !s.mdbKey.IsLegacy():
+			subKeys = append(subKeys, s.unescape(key[i+1:end-len(subKeyTerminator)], field))
+		default:
+			subKeys = append(subKeys, s.ascending(key[i+1:end], field))
+		}
+		i = end
+	}
+	if rest := len(key) - i; rest != 0 && rest != 4 {
+		return nil, fmt.Errorf("key末尾有多余的%d字节", rest)
+	}
+	return subKeys, nil
+}
+
+// ascending 倒序的子键按位取反还原
+func (s *MemIndex) ascending(b []byte, field int) []byte {
+	if !s.mdbKey.isDesc(field) {
+		return b
+	}
+	r := make([]byte, len(b))
+	for i := range b {
+		r[i] = ^b[i]
+	}
+	return r
+}
+
+func (s *MemIndex) unescape(b []byte, field int) []byte {
+	b = s.ascending(b, field)
+	r := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		r = append(r, b[i])
+		if b[i] == 0 {
+			i++
+		}
+	}
+	return r
+}
+
+func decodeValue(typ reflect.Type, b []byte) interface{} {
+	if typ != nil && typ.Kind() == reflect.Ptr && typ != bigIntType {
+		typ = typ.Elem()
+	}
+	n := len(b)
+	var v interface{}
+	switch {
+	case typ == nil:
+	case typ == timeType:
+		if n == 13 {
+			return time.Unix(int64(binary.BigEndian.Uint64(b[1:9])), int64(binary.BigEndian.Uint32(b[9:])))
+		}
+	case typ == bigIntType:
+		if v, ok := decodeBigInt(b); ok {
+			return v
+		}
+	default:
+		switch typ.Kind() {
+		case reflect.Bool:
+			if n == 1 {
+				v = b[0] != 0
+			}
+		case reflect.Int8:
+			if n == 2 {
+				v = int8(b[1])
+			}
+		case reflect.Int16:
+			if n == 3 {
+				v = int16(binary.BigEndian.Uint16(b[1:]))
+			}
+		case reflect.Int32, reflect.Int:
+			if n == 5 {
+				v = int32(binary.BigEndian.Uint32(b[1:]))
+			}
+		case reflect.Int64:
+			if n == 9 {
+				v = int64(binary.BigEndian.Uint64(b[1:]))
+			}
+		case reflect.Uint8:
+			if n == 1 {
+				v = b[0]
+			}
+		case reflect.Uint16:
+			if n == 2 {
+				v = binary.BigEndian.Uint16(b)
+			}
+		case reflect.Uint32, reflect.Uint:
+			if n == 4 {
+				v = binary.BigEndian.Uint32(b)
+			}
+		case reflect.Uint64:
+			if n == 8 {
+				v = binary.BigEndian.Uint64(b)
+			}
+		case reflect.Float32:
+			if n == 4 {
+				v = uint32ToFloat32(binary.BigEndian.Uint32(b))
+			}
+		case reflect.Float64:
+			if n == 8 {
+				v = uint64ToFloat64(binary.BigEndian.Uint64(b))
+			}
+		case reflect.String:
+			v = string(b)
+		}
+	}
+	if v != nil {
+		// 还原为字段自身的类型,如time.Duration
+		return reflect.ValueOf(v).Convert(typ).Interface()
+	}
+	return append([]byte(nil), b...)
+}
+
+func decodeBigInt(b []byte) (*big.Int, bool) {
+	if len(b) < 2 {
+		return nil, false
+	}
+	switch b[0] {
+	case '=':
+		return new(big.Int), len(b) == 2
+	case '>':
+		return new(big.Int).SetBytes(b[2:]), int(b[1]) == len(b)-2
+	case '-':
+		mag := make([]byte, len(b)-2)
+		for i := range mag {
+			mag[i] = ^b[i+2]
+		}
+		return new(big.Int).Neg(new(big.Int).SetBytes(mag)), 255-int(b[1]) == len(mag)
+	}
+	return nil, false
+}
+
+// formatKey 把key格式化为可读的字符串,解码失败时输出原始字节
+func (s *MemIndex) formatKey(key []byte) string {
+	vals, err := s.DecodeKey(key)
+	if err != nil {
+		return fmt.Sprintf("%q", key)
+	}
+	strs := make([]string, len(vals))
+	for i, v := range vals {
+		switch t := v.(type) {
+		case nil:
+			strs[i] = "NULL"
+		case string:
+			strs[i] = fmt.Sprintf("%q", t)
+		case []byte:
+			strs[i] = fmt.Sprintf("0x%x", t)
+		default:
+			strs[i] = fmt.Sprint(t)
+		}
+	}
+	return "(" + strings.Join(strs, ", ") + ")"
+}
+
+// keyString 错误信息中显示的key
+func (s *MemIndex) keyString(key []byte) string {
+	return string(s.name) + s.formatKey(key)
+}
+
+// Dump 按索引顺序输出每个key及对应的对象PrimaryID,用于调试
+func (s *MemIndex) Dump(w io.Writer) error {
+	var iter iradix.RawIterator
+	s.root.InitRawIterator(&iter)
+	if !iter.SeekPrefix(s.root.Root(), nil) {
+		return nil
+	}
+	for key, value, ok := iter.Next(); ok; key, value, ok = iter.Next() {
+		if _, err := fmt.Fprintf(w, "%s -> %d\n", s.formatKey(key), value.(IObject).GetID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
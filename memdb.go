@@ -25,6 +25,7 @@ const (
 type DatabaseResource struct {
 	resourceBase
 	factory     *ObjectFactory
+	transaction *Transaction
 	root        *iradix.Tree
 	ref         IObject
 	tempRef     IObject
@@ -137,8 +138,21 @@ type ObjectFactory struct {
 	indexs   []*MemIndex
 	indexMap map[string]int
 
-	actionTriggers []IActionTrigger
-	commitTriggers []ICommitTrigger
+	actionTriggers   []IActionTrigger
+	actionOptions    []*TriggerOptions
+	commitTriggers   []ICommitTrigger
+	commitOptions    []*TriggerOptions
+	rollbackTriggers []IRollbackTrigger
+	triggerFrame     *triggerFrame
+
+	foreignKeys  []*ForeignKey
+	referencedBy []*ForeignKey
+
+	validators []ValidatorFunc
+	checks     []fieldCheck
+
+	changeLogs []*ChangeLog
+	ttl        *ttlInfo
 }
 
 // Init 初始化
@@ -199,13 +213,31 @@ func (s *ObjectFactory) FindByPrimaryID(id uint32) Iterator {
 	return s.FindByIndex(0).AppendUInt32(id).Fire()
 }
 
+// FindOneByPrimaryID 根据主键查找对象,不创建迭代器
+func (s *ObjectFactory) FindOneByPrimaryID(id uint32) IObject {
+	return s.FindByIndex(0).AppendUInt32(id).FindOne()
+}
+
 // FindByPB 根据Protobuf结构查找,pb结果和记录结果的差别是,每个pb
 // 字段名字相同但是类型为指针类型
 func (s *ObjectFactory) FindByPB(pb interface{}) (Iterator, error) {
+	return s.findByPB(pb, false)
+}
+
+// FindByPBUsingPartial 同FindByPB,允许使用部分索引,调用方需确保要查找的对象在部分索引范围内
+func (s *ObjectFactory) FindByPBUsingPartial(pb interface{}) (Iterator, error) {
+	return s.findByPB(pb, true)
+}
+
+// findByPB 不使用多值索引及空间索引,usePartial为false时不使用部分索引
+func (s *ObjectFactory) findByPB(pb interface{}, usePartial bool) (Iterator, error) {
 	n := len(s.indexs)
 	var lastErr error
 	for i := 1; i < n; i++ {
 		idx := s.indexs[i]
+		if idx.multi || idx.spatial != nil || (idx.IsPartial() && !usePartial) {
+			continue
+		}
 		it, err := idx.FindByPB(pb)
 		if err != nil {
 			lastErr = err
@@ -292,26 +324,51 @@ func (s *ObjectFactory) Empty() bool {
 	return s.Count() == 0
 }
 
-// Add 添加对象
+// Add 添加对象,失败原因可以通过transaction.LastError读取
 func (s *ObjectFactory) Add(obj IObject, transaction *Transaction, reason int32) bool {
-	return s.internalAdd(obj, transaction, reason, true)
+	return s.TryAdd(obj, transaction, reason) == nil
 }
 
 // Update 更新对象
 func (s *ObjectFactory) Update(oldObj IObject, newObj IObject, transaction *Transaction, reason int32) bool {
-	return s.internalUpdate(oldObj, newObj, transaction, reason, true)
+	return s.TryUpdate(oldObj, newObj, transaction, reason) == nil
 }
 
 // Remove 添加对象
 func (s *ObjectFactory) Remove(obj IObject, transaction *Transaction, reason int32) bool {
-	return s.internalRemove(obj, transaction, reason, true)
+	return s.TryRemove(obj, transaction, reason) == nil
+}
+
+// TryAdd 添加对象,失败时返回失败原因
+func (s *ObjectFactory) TryAdd(obj IObject, transaction *Transaction, reason int32) error {
+	transaction, err := s.joinTriggerWrite(OpCreate, transaction)
+	if err == nil {
+		err = s.internalAdd(obj, transaction, reason, true)
+	}
+	return transaction.setError(transaction.abortOnExceed(recordTriggerError(err)))
+}
+
+// TryUpdate 更新对象,失败时返回失败原因
+func (s *ObjectFactory) TryUpdate(oldObj IObject, newObj IObject, transaction *Transaction, reason int32) error {
+	transaction, err := s.joinTriggerWrite(OpUpdate, transaction)
+	if err == nil {
+		err = s.internalUpdate(oldObj, newObj, transaction, reason, true)
+	}
+	return transaction.setError(transaction.abortOnExceed(recordTriggerError(err)))
+}
+
+// TryRemove 删除对象,失败时返回失败原因
+func (s *ObjectFactory) TryRemove(obj IObject, transaction *Transaction, reason int32) error {
+	transaction, err := s.joinTriggerWrite(OpDelete, transaction)
+	if err == nil {
+		err = s.internalRemove(obj, transaction, reason, true)
+	}
+	return transaction.setError(transaction.abortOnExceed(recordTriggerError(err)))
 }
 
 // AddActionTrigger 添加Action触发器
 func (s *ObjectFactory) AddActionTrigger(p IActionTrigger) IActionTrigger {
-	s.RemoveActionTrigger(p)
-	s.actionTriggers = append(s.actionTriggers, p)
-	return p
+	return s.addActionTrigger(p, nil)
 }
 
 // RemoveActionTrigger 移除Action触发器
@@ -319,6 +376,7 @@ func (s *ObjectFactory) RemoveActionTrigger(p IActionTrigger) {
 	for i, action := range s.actionTriggers {
 		if action == p {
 			s.actionTriggers = append(s.actionTriggers[:i], s.actionTriggers[i+1:]...)
+			s.actionOptions = append(s.actionOptions[:i], s.actionOptions[i+1:]...)
 			return
 		}
 	}
@@ -326,16 +384,35 @@ func (s *ObjectFactory) RemoveActionTrigger(p IActionTrigger) {
 
 // AddCommitTrigger 添加Commit触发器
 func (s *ObjectFactory) AddCommitTrigger(p ICommitTrigger) ICommitTrigger {
-	s.RemoveCommitTrigger(p)
-	s.commitTriggers = append(s.commitTriggers, p)
-	return p
+	return s.addCommitTrigger(p, nil)
 }
 
-// RemoveCommitTrigger 移除Commit触发器
+// RemoveCommitTrigger 移除Commit触发器,可以在Commit触发器回调中调用
 func (s *ObjectFactory) RemoveCommitTrigger(p ICommitTrigger) {
 	for i, action := range s.commitTriggers {
 		if action == p {
-			s.commitTriggers = append(s.commitTriggers[:i], s.commitTriggers[i+1:]...)
+			// 复制后删除,不影响正在遍历的触发器列表
+			triggers := make([]ICommitTrigger, 0, len(s.commitTriggers)-1)
+			s.commitTriggers = append(append(triggers, s.commitTriggers[:i]...), s.commitTriggers[i+1:]...)
+			options := make([]*TriggerOptions, 0, len(s.commitOptions)-1)
+			s.commitOptions = append(append(options, s.commitOptions[:i]...), s.commitOptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddRollbackTrigger 添加Rollback触发器
+func (s *ObjectFactory) AddRollbackTrigger(p IRollbackTrigger) IRollbackTrigger {
+	s.RemoveRollbackTrigger(p)
+	s.rollbackTriggers = append(s.rollbackTriggers, p)
+	return p
+}
+
+// RemoveRollbackTrigger 移除Rollback触发器
+func (s *ObjectFactory) RemoveRollbackTrigger(p IRollbackTrigger) {
+	for i, action := range s.rollbackTriggers {
+		if action == p {
+			s.rollbackTriggers = append(s.rollbackTriggers[:i], s.rollbackTriggers[i+1:]...)
 			return
 		}
 	}
@@ -361,11 +438,16 @@ func (s *ObjectFactory) name() string {
 
 // AddIndex 添加索引,返回索引编号
 func (s *ObjectFactory) AddIndex(fields string, makeKey MakeKeyFunc, unique bool) int {
+	return s.AddIndexWithOptions(fields, makeKey, IndexOptions{Unique: unique})
+}
+
+// AddIndexWithOptions 按选项添加索引
+func (s *ObjectFactory) AddIndexWithOptions(fields string, makeKey MakeKeyFunc, opts IndexOptions) int {
 	if idxNum, ok := s.indexMap[fields]; ok {
 		return idxNum
 	}
 	idxNum := len(s.indexs)
-	idx := NewMemIndex(fields, idxNum, makeKey, unique, s)
+	idx := NewMemIndexWithOptions(fields, idxNum, makeKey, opts, s)
 	_, _ = s.txn.Insert(idx.name, idx.root)
 	s.root = s.txn.Commit()
 	s.indexs = append(s.indexs, idx)
@@ -373,6 +455,61 @@ func (s *ObjectFactory) AddIndex(fields string, makeKey MakeKeyFunc, unique bool
 	return idxNum
 }
 
+// RebuildIndex 按主键索引中的对象重新生成索引,用于给已有数据的表添加索引或修改索引编码后迁移数据,
+// 表有未提交的修改时不能重建;失败时索引保持不变
+func (s *ObjectFactory) RebuildIndex(idxNum int) error {
+	if idxNum <= 0 || idxNum >= len(s.indexs) {
+		return fmt.Errorf("表[%s]重建索引失败: 索引[%d]不存在或是主键索引", s.Name, idxNum)
+	}
+	if s.txn.Dirty() {
+		return fmt.Errorf("表[%s]重建索引失败: 有未提交的修改", s.Name)
+	}
+	idx := s.indexs[idxNum]
+	oldTxn, oldRoot := idx.txn, idx.root
+	idx.txn = iradix.NewTxn()
+	idx.root = idx.txn.Root()
+	if oldRoot.IsSortGreat() {
+		idx.root.SortGreat()
+	}
+	for it := s.indexs[0].Begin(); it.Next(); {
+		if err := idx.Add(it.Value()); err != nil {
+			idx.txn, idx.root = oldTxn, oldRoot
+			return fmt.Errorf("表[%s]重建索引[%s]失败: %w", s.Name, idx.name, err)
+		}
+	}
+	idx.root = idx.txn.Commit()
+	s.updateIndexRoot(idx)
+	s.root = s.txn.Commit()
+	return nil
+}
+
+// MigrateIndex 把使用旧编码(IndexOptions.LegacyKey)的索引迁移到新编码并重建,
+// 外键使用的索引需要和对应的索引编码一致,不能单独迁移
+func (s *ObjectFactory) MigrateIndex(idxNum int) error {
+	if idxNum <= 0 || idxNum >= len(s.indexs) {
+		return fmt.Errorf("表[%s]迁移索引失败: 索引[%d]不存在或是主键索引", s.Name, idxNum)
+	}
+	idx := s.indexs[idxNum]
+	if !idx.mdbKey.IsLegacy() {
+		return nil
+	}
+	for _, fks := range [][]*ForeignKey{s.foreignKeys, s.referencedBy} {
+		for _, fk := range fks {
+			if fk.childIdx == idx || fk.parentIdx == idx {
+				return fmt.Errorf("表[%s]迁移索引[%s]失败: 索引被外键使用", s.Name, idx.name)
+			}
+		}
+	}
+	idx.mdbKey.SetLegacy(false)
+	idx.mdbKey1.SetLegacy(false)
+	if err := s.RebuildIndex(idxNum); err != nil {
+		idx.mdbKey.SetLegacy(true)
+		idx.mdbKey1.SetLegacy(true)
+		return err
+	}
+	return nil
+}
+
 // GetIndex GetIndex
 func (s *ObjectFactory) GetIndex(idxNum int) *MemIndex {
 	if idxNum < len(s.indexs) {
@@ -407,13 +544,27 @@ func (s *ObjectFactory) Begin(idxNum int) Iterator {
 	return s.indexs[idxNum].Begin()
 }
 
-func (s *ObjectFactory) internalAdd(obj IObject, transaction *Transaction, reason int32, notify bool) bool {
+func (s *ObjectFactory) internalAdd(obj IObject, transaction *Transaction, reason int32, notify bool) error {
 	if s.maxID > math.MaxInt32 {
 		formatndPanic("表[%s]Add失败: 超出最大记录数[%d]限制", s.Name, s.maxID)
 	}
+	if transaction == nil && gnilWrite == nil && s.notifyActions(notify) {
+		return withNilWrite(func() error {
+			return s.internalAdd(obj, transaction, reason, notify)
+		})
+	}
+	if err := transaction.checkLimits(); err != nil {
+		return err
+	}
 	obj.SetID(s.maxID)
-	if !s.beforeAdd(obj, transaction, reason, notify) {
-		return false
+	if err := s.validate(obj); err != nil {
+		return err
+	}
+	if err := s.checkReferences(nil, obj); err != nil {
+		return err
+	}
+	if err := s.beforeAdd(obj, transaction, reason, notify); err != nil {
+		return err
 	}
 	resource := s.makeResource(transaction, eCreate, obj, nil)
 	// var wg sync.WaitGroup
@@ -441,24 +592,44 @@ func (s *ObjectFactory) internalAdd(obj IObject, transaction *Transaction, reaso
 		s.updateIndexRoot(idx)
 	}
 	if transaction == nil {
-		s.commit()
-		s.afterAdd(obj, transaction, reason, notify)
-		s.commitAdd(obj, reason, notify)
+		s.commitNilWrite(eCreate, obj, nil, reason, notify)
 	} else {
 		transaction.AddResource(resource)
-		s.afterAdd(obj, transaction, reason, notify)
+		s.recordUndo(transaction, eCreate, obj, nil, reason, notify)
 	}
 	s.maxID++
-	return true
+	return s.afterAdd(obj, transaction, reason, notify)
 }
 
-func (s *ObjectFactory) internalUpdate(oldObj IObject, newObj IObject, transaction *Transaction, reason int32, notify bool) bool {
+func (s *ObjectFactory) internalUpdate(oldObj IObject, newObj IObject, transaction *Transaction, reason int32, notify bool) error {
 	if oldObj.GetID() == 0 {
 		formatndPanic("表[%s]Update: 更新无效对象(未设置对象ID),请查询后再更新", s.Name)
 	}
+	if transaction == nil && len(s.referencedBy) > 0 {
+		return s.withImplicitTransaction(reason, func(transaction *Transaction) error {
+			return s.internalUpdate(oldObj, newObj, transaction, reason, notify)
+		})
+	}
+	if transaction == nil && gnilWrite == nil && s.notifyActions(notify) {
+		return withNilWrite(func() error {
+			return s.internalUpdate(oldObj, newObj, transaction, reason, notify)
+		})
+	}
+	if err := transaction.checkLimits(); err != nil {
+		return err
+	}
 	newObj.SetID(oldObj.GetID())
-	if !s.beforeUpdate(oldObj, newObj, transaction, reason, notify) {
-		return false
+	if err := s.validate(newObj); err != nil {
+		return err
+	}
+	if err := s.checkReferences(oldObj, newObj); err != nil {
+		return err
+	}
+	if err := s.beforeUpdate(oldObj, newObj, transaction, reason, notify); err != nil {
+		return err
+	}
+	if err := s.applyReferences(oldObj, newObj, transaction, reason); err != nil {
+		return err
 	}
 	resource := s.makeResource(transaction, eUpdate, oldObj, newObj)
 	for _, idx := range s.indexs {
@@ -470,22 +641,36 @@ func (s *ObjectFactory) internalUpdate(oldObj IObject, newObj IObject, transacti
 		s.updateIndexRoot(idx)
 	}
 	if transaction == nil {
-		s.commit()
-		s.afterUpdate(newObj, transaction, reason, notify)
-		s.commitUpdate(oldObj, newObj, reason, notify)
+		s.commitNilWrite(eUpdate, oldObj, newObj, reason, notify)
 	} else {
 		transaction.AddResource(resource)
-		s.afterUpdate(newObj, transaction, reason, notify)
+		s.recordUndo(transaction, eUpdate, oldObj, newObj, reason, notify)
 	}
-	return true
+	return s.afterUpdate(newObj, transaction, reason, notify)
 }
 
-func (s *ObjectFactory) internalRemove(obj IObject, transaction *Transaction, reason int32, notify bool) bool {
+func (s *ObjectFactory) internalRemove(obj IObject, transaction *Transaction, reason int32, notify bool) error {
 	if obj.GetID() == 0 {
 		formatndPanic("表[%s]Remove: 删除无效对象(未设置对象ID),请查询后再删除", s.Name)
 	}
-	if !s.beforeRemove(obj, transaction, reason, notify) {
-		return false
+	if transaction == nil && len(s.referencedBy) > 0 {
+		return s.withImplicitTransaction(reason, func(transaction *Transaction) error {
+			return s.internalRemove(obj, transaction, reason, notify)
+		})
+	}
+	if transaction == nil && gnilWrite == nil && s.notifyActions(notify) {
+		return withNilWrite(func() error {
+			return s.internalRemove(obj, transaction, reason, notify)
+		})
+	}
+	if err := transaction.checkLimits(); err != nil {
+		return err
+	}
+	if err := s.beforeRemove(obj, transaction, reason, notify); err != nil {
+		return err
+	}
+	if err := s.applyReferences(obj, nil, transaction, reason); err != nil {
+		return err
 	}
 	resource := s.makeResource(transaction, eDelete, obj, nil)
 	for _, idx := range s.indexs {
@@ -497,103 +682,250 @@ func (s *ObjectFactory) internalRemove(obj IObject, transaction *Transaction, re
 		s.updateIndexRoot(idx)
 	}
 	if transaction == nil {
-		s.commit()
-		s.commitRemove(obj, reason, notify)
+		s.commitNilWrite(eDelete, obj, nil, reason, notify)
 	} else {
 		transaction.AddResource(resource)
+		s.recordUndo(transaction, eDelete, obj, nil, reason, notify)
 	}
-	return true
+	return s.afterRemove(obj, transaction, reason, notify)
 }
 
-func (s *ObjectFactory) beforeAdd(obj IObject, transaction *Transaction, reason int32, notify bool) bool {
-	if notify {
-		for _, action := range s.actionTriggers {
-			if !action.BeforeAdd(s.FactoryID, obj, transaction, reason) {
-				return false
+// notifyActions 操作是否会执行动作触发器
+func (s *ObjectFactory) notifyActions(notify bool) bool {
+	return notify && len(s.actionTriggers) > 0
+}
+
+// withImplicitTransaction 在内部事物中执行会产生级联修改的非事物操作,级联修改失败时整体回滚
+func (s *ObjectFactory) withImplicitTransaction(reason int32, fn func(transaction *Transaction) error) error {
+	transaction := NewTransaction()
+	if err := fn(transaction); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.TryCommit(reason)
+}
+
+func (s *ObjectFactory) beforeAdd(obj IObject, transaction *Transaction, reason int32, notify bool) error {
+	if notify && len(s.actionTriggers) > 0 {
+		frame := s.enterTriggers(transaction)
+		defer leaveTriggers(frame)
+		for i, action := range s.actionTriggers {
+			if !s.actionOptions[i].match(OpCreate, reason, obj) {
+				continue
+			}
+			if p, ok := action.(IVetoActionTrigger); ok {
+				if err := p.CheckAdd(s.FactoryID, obj, transaction, reason); err != nil {
+					return s.veto(OpCreate, i, action, reason, err)
+				}
+			} else if !action.BeforeAdd(s.FactoryID, obj, transaction, reason) {
+				return s.veto(OpCreate, i, action, reason, ErrTriggerVeto)
 			}
 		}
+		return frame.err
 	}
-	return true
+	return nil
 }
 
-func (s *ObjectFactory) afterAdd(obj IObject, transaction *Transaction, reason int32, notify bool) {
-	if notify {
-		for _, action := range s.actionTriggers {
+func (s *ObjectFactory) afterAdd(obj IObject, transaction *Transaction, reason int32, notify bool) error {
+	if notify && len(s.actionTriggers) > 0 {
+		frame := s.enterTriggers(transaction)
+		defer leaveTriggers(frame)
+		for i, action := range s.actionTriggers {
+			if !s.actionOptions[i].match(OpCreate, reason, obj) {
+				continue
+			}
 			action.AfterAdd(s.FactoryID, obj, transaction, reason)
 		}
+		return frame.err
 	}
+	return nil
 }
 
-func (s *ObjectFactory) beforeUpdate(oldObj IObject, newObj IObject, transaction *Transaction, reason int32, notify bool) bool {
-	if notify {
-		for _, action := range s.actionTriggers {
-			if !action.BeforeUpdate(s.FactoryID, oldObj, newObj, transaction, reason) {
-				return false
+func (s *ObjectFactory) beforeUpdate(oldObj IObject, newObj IObject, transaction *Transaction, reason int32, notify bool) error {
+	if notify && len(s.actionTriggers) > 0 {
+		frame := s.enterTriggers(transaction)
+		defer leaveTriggers(frame)
+		for i, action := range s.actionTriggers {
+			if !s.actionOptions[i].match(OpUpdate, reason, newObj) {
+				continue
+			}
+			if p, ok := action.(IVetoActionTrigger); ok {
+				if err := p.CheckUpdate(s.FactoryID, oldObj, newObj, transaction, reason); err != nil {
+					return s.veto(OpUpdate, i, action, reason, err)
+				}
+			} else if !action.BeforeUpdate(s.FactoryID, oldObj, newObj, transaction, reason) {
+				return s.veto(OpUpdate, i, action, reason, ErrTriggerVeto)
 			}
 		}
+		return frame.err
 	}
-	return true
+	return nil
 }
 
-func (s *ObjectFactory) afterUpdate(obj IObject, transaction *Transaction, reason int32, notify bool) {
-	if notify {
-		for _, action := range s.actionTriggers {
+func (s *ObjectFactory) afterUpdate(obj IObject, transaction *Transaction, reason int32, notify bool) error {
+	if notify && len(s.actionTriggers) > 0 {
+		frame := s.enterTriggers(transaction)
+		defer leaveTriggers(frame)
+		for i, action := range s.actionTriggers {
+			if !s.actionOptions[i].match(OpUpdate, reason, obj) {
+				continue
+			}
 			action.AfterUpdate(s.FactoryID, obj, transaction, reason)
 		}
+		return frame.err
 	}
+	return nil
 }
 
-func (s *ObjectFactory) beforeRemove(obj IObject, transaction *Transaction, reason int32, notify bool) bool {
-	if notify {
-		for _, action := range s.actionTriggers {
-			if !action.BeforeRemove(s.FactoryID, obj, transaction, reason) {
-				return false
+func (s *ObjectFactory) beforeRemove(obj IObject, transaction *Transaction, reason int32, notify bool) error {
+	if notify && len(s.actionTriggers) > 0 {
+		frame := s.enterTriggers(transaction)
+		defer leaveTriggers(frame)
+		for i, action := range s.actionTriggers {
+			if !s.actionOptions[i].match(OpDelete, reason, obj) {
+				continue
+			}
+			if p, ok := action.(IVetoActionTrigger); ok {
+				if err := p.CheckRemove(s.FactoryID, obj, transaction, reason); err != nil {
+					return s.veto(OpDelete, i, action, reason, err)
+				}
+			} else if !action.BeforeRemove(s.FactoryID, obj, transaction, reason) {
+				return s.veto(OpDelete, i, action, reason, ErrTriggerVeto)
 			}
 		}
+		return frame.err
 	}
-	return true
+	return nil
+}
+
+func (s *ObjectFactory) veto(op ChangeOp, i int, action IActionTrigger, reason int32, err error) error {
+	return &TriggerVetoError{Table: s.Name, Op: op, Trigger: action, Index: i, Reason: reason, Err: err}
 }
 
-func (s *ObjectFactory) commitAdd(obj IObject, reason int32, notify bool) {
+func (s *ObjectFactory) afterRemove(obj IObject, transaction *Transaction, reason int32, notify bool) error {
+	if notify && len(s.actionTriggers) > 0 {
+		frame := s.enterTriggers(transaction)
+		defer leaveTriggers(frame)
+		for i, action := range s.actionTriggers {
+			if !s.actionOptions[i].match(OpDelete, reason, obj) {
+				continue
+			}
+			if p, ok := action.(IAfterRemoveTrigger); ok {
+				p.AfterRemove(s.FactoryID, obj, transaction, reason)
+			}
+		}
+		return frame.err
+	}
+	return nil
+}
+
+func (s *ObjectFactory) commitAdd(obj IObject, seq uint64, reason int32, notify bool) {
+	s.logChange(seq, OpCreate, nil, obj, reason)
 	if notify {
-		for _, action := range s.commitTriggers {
+		options := s.commitOptions
+		for i, action := range s.commitTriggers {
+			if !options[i].match(OpCreate, reason, obj) {
+				continue
+			}
 			action.CommitAdd(s.FactoryID, obj, reason)
 		}
 	}
 }
 
-func (s *ObjectFactory) commitUpdate(oldObj IObject, newObj IObject, reason int32, notify bool) {
+func (s *ObjectFactory) commitUpdate(oldObj IObject, newObj IObject, seq uint64, reason int32, notify bool) {
+	s.logChange(seq, OpUpdate, oldObj, newObj, reason)
 	if notify {
-		for _, action := range s.commitTriggers {
-			action.CommitUpdate(s.FactoryID, oldObj, newObj, reason)
+		var fields ChangedFields
+		diffed := false
+		options := s.commitOptions
+		for i, action := range s.commitTriggers {
+			if !options[i].match(OpUpdate, reason, newObj) {
+				continue
+			}
+			if p, ok := action.(IFieldCommitTrigger); ok {
+				if !diffed {
+					fields, diffed = s.ChangedFields(oldObj, newObj), true
+				}
+				p.CommitUpdateFields(s.FactoryID, oldObj, newObj, fields, reason)
+			} else {
+				action.CommitUpdate(s.FactoryID, oldObj, newObj, reason)
+			}
 		}
 	}
 }
 
-func (s *ObjectFactory) commitRemove(obj IObject, reason int32, notify bool) {
+func (s *ObjectFactory) commitRemove(obj IObject, seq uint64, reason int32, notify bool) {
+	s.logChange(seq, OpDelete, obj, nil, reason)
 	if notify {
-		for _, action := range s.commitTriggers {
+		options := s.commitOptions
+		for i, action := range s.commitTriggers {
+			if !options[i].match(OpDelete, reason, obj) {
+				continue
+			}
 			action.CommitRemove(s.FactoryID, obj, reason)
 		}
 	}
 }
 
+func (s *ObjectFactory) recordUndo(transaction *Transaction, t eDBResourceType, obj IObject, newObj IObject, reason int32, notify bool) {
+	if notify && len(s.rollbackTriggers) > 0 {
+		transaction.undoLog = append(transaction.undoLog, undoRecord{
+			factory: s, t: t, obj: obj, newObj: newObj, reason: reason, pos: len(transaction.resources),
+		})
+	}
+}
+
+func (s *ObjectFactory) rollbackNotify(t eDBResourceType, obj IObject, newObj IObject, reason int32) {
+	for _, action := range s.rollbackTriggers {
+		switch t {
+		case eCreate:
+			action.RollbackAdd(s.FactoryID, obj, reason)
+		case eUpdate:
+			action.RollbackUpdate(s.FactoryID, obj, newObj, reason)
+		case eDelete:
+			action.RollbackRemove(s.FactoryID, obj, reason)
+		}
+	}
+}
+
 func (s *ObjectFactory) makeResource(transaction *Transaction, t eDBResourceType, ref IObject, tempRef IObject) *DatabaseResource {
 	savePointID := -1
 	if transaction != nil {
 		savePointID = transaction.LastSavePointID()
 		savePointID2 := s.txn.LastSavePointID()
+		if savePointID < savePointID2 {
+			formatndPanic("事物回滚点异常")
+		}
 		if savePointID != savePointID2 {
-			if savePointID != savePointID2+1 {
-				formatndPanic("事物回滚点异常")
+			if !s.txn.Dirty() {
+				// 表上没有未提交的修改时第一次分配的是初始回滚点
+				s.allocSavePoint()
 			}
-			s.txn.AllocSavePoint()
-			for _, idx := range s.indexs {
-				idx.txn.AllocSavePoint()
+			// 表在中间的回滚点之后没有修改过,这些回滚点的状态相同
+			for s.txn.LastSavePointID() < savePointID {
+				s.allocSavePoint()
 			}
 		}
 	}
-	return &DatabaseResource{factory: s, ref: ref, tempRef: tempRef, t: t, root: s.root, savePointID: savePointID}
+	return &DatabaseResource{factory: s, transaction: transaction, ref: ref, tempRef: tempRef, t: t, root: s.root, savePointID: savePointID}
+}
+
+func (s *ObjectFactory) allocSavePoint() {
+	s.txn.AllocSavePoint()
+	for _, idx := range s.indexs {
+		idx.txn.AllocSavePoint()
+	}
+}
+
+// releaseSavePoint 释放事物回滚点savePointID对应的表回滚点
+func (s *ObjectFactory) releaseSavePoint(savePointID int) {
+	if s.txn.LastSavePointID() != savePointID {
+		return
+	}
+	s.txn.ReleaseSavePoint()
+	for _, idx := range s.indexs {
+		idx.txn.ReleaseSavePoint()
+	}
 }
 
 func (s *ObjectFactory) loopIndex(cb func(idx *MemIndex)) {
@@ -635,13 +967,13 @@ func (s *ObjectFactory) FreeListLen() int {
 func (s *DatabaseResource) Commit(reason int32) {
 	switch s.t {
 	case eCreate:
-		s.factory.commitAdd(s.ref, reason, true)
+		s.factory.commitAdd(s.ref, s.transaction.commitSeq, reason, true)
 		break
 	case eUpdate:
-		s.factory.commitUpdate(s.ref, s.tempRef, reason, true)
+		s.factory.commitUpdate(s.ref, s.tempRef, s.transaction.commitSeq, reason, true)
 		break
 	case eDelete:
-		s.factory.commitRemove(s.ref, reason, true)
+		s.factory.commitRemove(s.ref, s.transaction.commitSeq, reason, true)
 		break
 	case eNone:
 		break
@@ -751,3 +1083,22 @@ func (s *DatabaseResource) id() uint32 {
 func (s *DatabaseResource) subID() uint32 {
 	return s.ref.GetID()
 }
+
+func (s *DatabaseResource) change() Change {
+	change := Change{FactoryID: s.factory.FactoryID, Table: s.factory.Name}
+	switch s.t {
+	case eCreate:
+		change.Op = OpCreate
+		change.New = s.ref
+	case eUpdate:
+		change.Op = OpUpdate
+		change.Old = s.ref
+		change.New = s.tempRef
+	case eDelete:
+		change.Op = OpDelete
+		change.Old = s.ref
+	default:
+		change.Op = opNone
+	}
+	return change
+}
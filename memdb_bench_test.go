@@ -3,6 +3,7 @@ package gmemdb_test
 import (
 	"math/rand"
 	"strconv"
+	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -122,3 +123,54 @@ var _ = Describe("性能测试2", func() {
 		}, 5)
 	}
 })
+
+var _ = Describe("性能测试3", func() {
+	if testBench {
+		testCount := 100000
+		var mdb *testObjMDB
+		BeforeEach(func() {
+			mdb = newTestObjMDB(true)
+			for i := 1; i <= testCount; i++ {
+				obj := &dbTestObj{Name: strconv.Itoa(i), ID1: int32(i), ID2: int32(testCount + i), Address: ""}
+				mdb.Add(obj, nil, 0)
+			}
+		})
+
+		Measure("唯一索引查找性能测试", func(b Benchmarker) {
+			find := func(i int) gmemdb.MdbFinder {
+				return mdb.FindByIndexName("ID1|ID2").AppendInt32(int32(i)).AppendInt32(int32(testCount + i))
+			}
+			rt := b.Time("Fire查找耗时", func() {
+				for i := 1; i <= testCount; i++ {
+					if find(i).Fire().Step() == nil {
+						Fail("对象不存在")
+					}
+				}
+			})
+			b.RecordValue("Fire查找速度(条 / 每秒)", float64(testCount)/rt.Seconds())
+
+			var cursor gmemdb.Cursor
+			rt = b.Time("FireInto查找耗时", func() {
+				for i := 1; i <= testCount; i++ {
+					if find(i).FireInto(&cursor).Step() == nil {
+						Fail("对象不存在")
+					}
+				}
+			})
+			b.RecordValue("FireInto查找速度(条 / 每秒)", float64(testCount)/rt.Seconds())
+
+			rt = b.Time("FindOne查找耗时", func() {
+				for i := 1; i <= testCount; i++ {
+					if find(i).FindOne() == nil {
+						Fail("对象不存在")
+					}
+				}
+			})
+			b.RecordValue("FindOne查找速度(条 / 每秒)", float64(testCount)/rt.Seconds())
+
+			b.RecordValue("Fire每次查找分配次数", testing.AllocsPerRun(1000, func() { find(testCount / 2).Fire().Step() }))
+			b.RecordValue("FireInto每次查找分配次数", testing.AllocsPerRun(1000, func() { find(testCount / 2).FireInto(&cursor).Step() }))
+			b.RecordValue("FindOne每次查找分配次数", testing.AllocsPerRun(1000, func() { find(testCount / 2).FindOne() }))
+		}, 5)
+	}
+})
@@ -0,0 +1,109 @@
+package gmemdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// makeKeys 生成多值索引的所有key,已排序并去重
+func (s *MemIndex) makeKeys(mdbKey *MdbKey, val IObject) ([][]byte, error) {
+	var keys [][]byte
+	id := val.GetID()
+	mdbKey.onEmit = func(key *MdbKey) error {
+		if key.KeyNum() == 0 {
+			return nil
+		}
+		if err := s.appendID(key, id); err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte(nil), key.Key()...))
+		return nil
+	}
+	defer func() { mdbKey.onEmit = nil }()
+	mdbKey.Reset()
+	if err := s.makeKey(mdbKey, val); err != nil {
+		return nil, err
+	}
+	if err := mdbKey.Emit(); err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	n := 0
+	for i, key := range keys {
+		if i > 0 && bytes.Equal(key, keys[n-1]) {
+			continue
+		}
+		keys[n] = key
+		n++
+	}
+	return keys[:n], nil
+}
+
+func (s *MemIndex) addMulti(val IObject) error {
+	keys, err := s.makeKeys(&s.mdbKey, val)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, didUpdate := s.txn.Insert(key, val); didUpdate {
+			return fmt.Errorf("索引冲突 %s", s.keyString(key))
+		}
+	}
+	s.root = s.txn.Root()
+	return nil
+}
+
+func (s *MemIndex) updateMulti(oldVal IObject, newVal IObject) error {
+	oldKeys, err := s.makeKeys(&s.mdbKey, oldVal)
+	if err != nil {
+		return err
+	}
+	newKeys, err := s.makeKeys(&s.mdbKey1, newVal)
+	if err != nil {
+		return err
+	}
+	i, j := 0, 0
+	for i < len(oldKeys) || j < len(newKeys) {
+		c := 0
+		if i == len(oldKeys) {
+			c = 1
+		} else if j == len(newKeys) {
+			c = -1
+		} else {
+			c = bytes.Compare(oldKeys[i], newKeys[j])
+		}
+		switch {
+		case c < 0:
+			if _, ok := s.txn.Delete(oldKeys[i]); !ok {
+				return fmt.Errorf("源索引不存在 %s", s.keyString(oldKeys[i]))
+			}
+			i++
+		case c > 0:
+			if _, didUpdate := s.txn.Insert(newKeys[j], newVal); didUpdate {
+				return fmt.Errorf("新索引冲突 %s", s.keyString(newKeys[j]))
+			}
+			j++
+		default:
+			if _, didUpdate := s.txn.Insert(newKeys[j], newVal); !didUpdate {
+				return fmt.Errorf("源索引不存在 %s", s.keyString(newKeys[j]))
+			}
+			i++
+			j++
+		}
+	}
+	s.root = s.txn.Root()
+	return nil
+}
+
+func (s *MemIndex) deleteMulti(val IObject) error {
+	keys, err := s.makeKeys(&s.mdbKey, val)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s.txn.Delete(key)
+	}
+	s.root = s.txn.Root()
+	return nil
+}
@@ -0,0 +1,98 @@
+package gmemdb
+
+import "sync/atomic"
+
+// Participant 事物参与者,外部资源(缓存、消息发件箱、文件等)通过Transaction.Enlist
+// 加入事物,和数据表一起提交或回滚。参与者需要是可比较的类型(一般为指针)
+type Participant interface {
+	// Prepare 提交前调用,返回错误时整个事物回滚,TryCommit返回该错误
+	Prepare() error
+	// Commit 提交
+	Commit(reason int32)
+	// Rollback 回滚到回滚点savePointID之前的状态,-1表示回滚全部修改
+	Rollback(savePointID int)
+}
+
+const participantIDFlag = 0x80000000
+
+var gparticipantID uint32
+
+// participantResource 参与者事物资源
+type participantResource struct {
+	resourceBase
+	p           Participant
+	pid         uint32
+	savePointID int
+}
+
+// Enlist 将参与者加入事物,参与者在每次修改自身状态前调用,savePointID为
+// 当前回滚点(同Transaction.LastSavePointID),回滚到该回滚点时参与者会收到
+// Rollback(savePointID)
+func (s *Transaction) Enlist(p Participant) (savePointID int, err error) {
+	if err = s.checkLimits(); err != nil {
+		return 0, s.abortOnExceed(err)
+	}
+	if s.participants == nil {
+		s.participants = make(map[Participant]uint32)
+	}
+	pid, ok := s.participants[p]
+	if !ok {
+		pid = participantIDFlag | atomic.AddUint32(&gparticipantID, 1)
+		s.participants[p] = pid
+	}
+	savePointID = s.LastSavePointID()
+	s.AddResource(&participantResource{p: p, pid: pid, savePointID: savePointID})
+	return savePointID, nil
+}
+
+// prepareParticipants 提交前按加入顺序调用每个参与者的Prepare
+func (s *Transaction) prepareParticipants() error {
+	if len(s.participants) == 0 {
+		return nil
+	}
+	prepared := make(map[uint32]bool, len(s.participants))
+	for _, resource := range s.resources {
+		res, ok := resource.(*participantResource)
+		if !ok || prepared[res.pid] {
+			continue
+		}
+		prepared[res.pid] = true
+		if err := res.p.Prepare(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *participantResource) tag() resourceTag {
+	return eParticipant
+}
+
+func (s *participantResource) id() uint32 {
+	return s.pid
+}
+
+func (s *participantResource) subID() uint32 {
+	return 0
+}
+
+// Commit 提交
+func (s *participantResource) Commit(reason int32) {
+	s.p.Commit(reason)
+}
+
+// Rollback 回滚
+func (s *participantResource) Rollback() {
+	s.p.Rollback(s.savePointID)
+}
+
+func (s *participantResource) isControl() bool {
+	return false
+}
+
+func (s *participantResource) free() {
+}
+
+func (s *participantResource) merge(preResource Resource) mergeResult {
+	return eMergeOk
+}
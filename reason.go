@@ -0,0 +1,110 @@
+package gmemdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Reason 修改原因,所有修改接口及触发器中的reason参数
+type Reason int32
+
+func (s Reason) String() string {
+	if info, ok := LookupReason(int32(s)); ok {
+		return info.Name
+	}
+	return "Reason(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ReasonInfo 注册的修改原因
+type ReasonInfo struct {
+	Code Reason
+	Name string
+	Desc string
+	Meta map[string]interface{}
+}
+
+var (
+	greasonLock sync.RWMutex
+	greasons    = make(map[Reason]ReasonInfo)
+)
+
+// RegisterReason 注册修改原因,同一个原因码只能注册一次
+func RegisterReason(code int32, name string, desc string) Reason {
+	return RegisterReasonInfo(ReasonInfo{Code: Reason(code), Name: name, Desc: desc})
+}
+
+// RegisterReasonInfo 注册带附加信息的修改原因
+func RegisterReasonInfo(info ReasonInfo) Reason {
+	if info.Name == "" {
+		formatndPanic("注册修改原因[%d]失败: 名字为空", info.Code)
+	}
+	greasonLock.Lock()
+	defer greasonLock.Unlock()
+	if pre, ok := greasons[info.Code]; ok {
+		formatndPanic("注册修改原因[%d]%s失败: 已注册为%s", info.Code, info.Name, pre.Name)
+	}
+	greasons[info.Code] = info
+	return info.Code
+}
+
+// LookupReason 查询修改原因
+func LookupReason(code int32) (ReasonInfo, bool) {
+	greasonLock.RLock()
+	defer greasonLock.RUnlock()
+	info, ok := greasons[Reason(code)]
+	return info, ok
+}
+
+// ReasonName 修改原因的名字,未注册时返回Reason(code)
+func ReasonName(code int32) string {
+	return Reason(code).String()
+}
+
+// Reasons 按原因码排序返回所有注册的修改原因
+func Reasons() []ReasonInfo {
+	greasonLock.RLock()
+	defer greasonLock.RUnlock()
+	infos := make([]ReasonInfo, 0, len(greasons))
+	for _, info := range greasons {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Code < infos[j].Code })
+	return infos
+}
+
+// ReasonName 修改原因的名字
+func (s *ChangeEvent) ReasonName() string {
+	return ReasonName(s.Reason)
+}
+
+// ReasonName 修改原因的名字
+func (s *ChangeBatch) ReasonName() string {
+	return ReasonName(s.Reason)
+}
+
+func (s ChangeBatch) String() string {
+	return fmt.Sprintf("#%d %s %d changes", s.Seq, ReasonName(s.Reason), len(s.Changes))
+}
+
+// SetValue 在事物上附加上下文(如操作者、请求ID),触发器中可以通过Value读取,提交或回滚后清除
+func (s *Transaction) SetValue(key interface{}, value interface{}) {
+	if s.values == nil {
+		s.values = make(map[interface{}]interface{})
+	}
+	s.values[key] = value
+}
+
+// Value 读取事物上附加的上下文,transaction为nil时返回nil
+func (s *Transaction) Value(key interface{}) interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.values[key]
+}
+
+// ClearValues 清除事物上附加的上下文,事物提交或回滚时会自动清除
+func (s *Transaction) ClearValues() {
+	s.values = nil
+}
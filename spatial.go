@@ -0,0 +1,208 @@
+package gmemdb
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/jxlczjp77/gmemdb/iradix"
+)
+
+// Point 二维坐标
+type Point struct {
+	X, Y float64
+}
+
+// Rect 二维矩形区域,包含边界
+type Rect struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// Contains 点是否在矩形内
+func (s Rect) Contains(p Point) bool {
+	return p.X >= s.MinX && p.X <= s.MaxX && p.Y >= s.MinY && p.Y <= s.MaxY
+}
+
+// 查询覆盖的网格超过这个数量且多于索引记录数时直接遍历索引
+const maxSpatialScanCells = 4096
+
+type spatialInfo struct {
+	x, y     []int
+	cellSize float64
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
+
+func numberValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func (s *spatialInfo) point(obj IObject) Point {
+	val := reflect.ValueOf(obj).Elem()
+	return Point{X: numberValue(val.FieldByIndex(s.x)), Y: numberValue(val.FieldByIndex(s.y))}
+}
+
+func (s *spatialInfo) cell(v float64) uint32 {
+	c := math.Floor(v / s.cellSize)
+	if c < math.MinInt32 {
+		c = math.MinInt32
+	} else if c > math.MaxInt32 {
+		c = math.MaxInt32
+	}
+	return uint32(int64(c) - math.MinInt32)
+}
+
+// interleave 把x、y的二进制位交错为网格的哈希值
+func interleave(x, y uint32) uint64 {
+	spread := func(v uint32) uint64 {
+		u := uint64(v)
+		u = (u | u<<16) & 0x0000FFFF0000FFFF
+		u = (u | u<<8) & 0x00FF00FF00FF00FF
+		u = (u | u<<4) & 0x0F0F0F0F0F0F0F0F
+		u = (u | u<<2) & 0x3333333333333333
+		u = (u | u<<1) & 0x5555555555555555
+		return u
+	}
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave interleave的逆运算
+func deinterleave(z uint64) (uint32, uint32) {
+	compact := func(u uint64) uint32 {
+		u &= 0x5555555555555555
+		u = (u | u>>1) & 0x3333333333333333
+		u = (u | u>>2) & 0x0F0F0F0F0F0F0F0F
+		u = (u | u>>4) & 0x00FF00FF00FF00FF
+		u = (u | u>>8) & 0x0000FFFF0000FFFF
+		u = (u | u>>16) & 0x00000000FFFFFFFF
+		return uint32(u)
+	}
+	return compact(z), compact(z >> 1)
+}
+
+// decodeCell 把空间索引的子键还原为x、y所在的网格坐标
+func (s *spatialInfo) decodeCell(sub []byte) []interface{} {
+	if len(sub) != 8 {
+		return []interface{}{sub}
+	}
+	x, y := deinterleave(binary.BigEndian.Uint64(sub))
+	return []interface{}{int64(x) + math.MinInt32, int64(y) + math.MinInt32}
+}
+
+// AddSpatialIndex 给二维坐标字段添加网格索引,索引名为"xField|yField",
+// 坐标按cellSize划分网格,网格坐标哈希为一个子键,查询时逐个网格查找;
+// 空间索引只能通过Within、Nearby查询,不参与FindByPB及外键
+func (s *ObjectFactory) AddSpatialIndex(xField string, yField string, cellSize float64) int {
+	x, okX := s.Type.FieldByName(xField)
+	y, okY := s.Type.FieldByName(yField)
+	if !okX || !okY || !isNumberKind(x.Type.Kind()) || !isNumberKind(y.Type.Kind()) {
+		formatndPanic("表[%s]添加空间索引失败: 列[%s,%s]不存在或不是数值", s.Name, xField, yField)
+	}
+	if cellSize <= 0 {
+		formatndPanic("表[%s]添加空间索引失败: 无效的网格大小%v", s.Name, cellSize)
+	}
+	name := xField + "|" + yField
+	if _, ok := s.indexMap[name]; ok {
+		formatndPanic("表[%s]添加空间索引失败: 索引[%s]已存在", s.Name, name)
+	}
+	info := &spatialInfo{x: x.Index, y: y.Index, cellSize: cellSize}
+	idxNum := s.AddIndex(name, func(key *MdbKey, obj IObject) error {
+		p := info.point(obj)
+		return key.AppendUInt64(interleave(info.cell(p.X), info.cell(p.Y)))
+	}, false)
+	idx := s.indexs[idxNum]
+	// 两个坐标编码为一个子键
+	idx.mdbKey.Init(1, false)
+	idx.mdbKey1.Init(1, false)
+	idx.spatial = info
+	return idxNum
+}
+
+// IsSpatialIndex 是否空间索引
+func (s *MemIndex) IsSpatialIndex() bool {
+	return s.spatial != nil
+}
+
+// Within 查找坐标在矩形内的对象,按PrimaryID排序
+func (s *MemIndex) Within(rect Rect) Iterator {
+	objs := s.searchRect(rect, rect.Contains)
+	sort.Slice(objs, func(i, j int) bool { return objs[i].GetID() < objs[j].GetID() })
+	return &sliceIterator{txn: s.txn, objs: objs}
+}
+
+// Nearby 查找与center距离不超过radius的对象,按距离从近到远排序
+func (s *MemIndex) Nearby(center Point, radius float64) Iterator {
+	dist := func(p Point) float64 {
+		return math.Hypot(p.X-center.X, p.Y-center.Y)
+	}
+	rect := Rect{MinX: center.X - radius, MinY: center.Y - radius, MaxX: center.X + radius, MaxY: center.Y + radius}
+	objs := s.searchRect(rect, func(p Point) bool { return dist(p) <= radius })
+	sort.SliceStable(objs, func(i, j int) bool {
+		di, dj := dist(s.spatial.point(objs[i])), dist(s.spatial.point(objs[j]))
+		if di != dj {
+			return di < dj
+		}
+		return objs[i].GetID() < objs[j].GetID()
+	})
+	return &sliceIterator{txn: s.txn, objs: objs}
+}
+
+func (s *MemIndex) searchRect(rect Rect, match func(p Point) bool) []IObject {
+	if s.spatial == nil {
+		formatndPanic("索引[%s]不是空间索引", s.name)
+	}
+	var objs []IObject
+	if rect.MinX > rect.MaxX || rect.MinY > rect.MaxY {
+		return objs
+	}
+	collect := func(value interface{}) {
+		obj := value.(IObject)
+		if match(s.spatial.point(obj)) {
+			objs = append(objs, obj)
+		}
+	}
+
+	var iter iradix.RawIterator
+	minX, maxX := s.spatial.cell(rect.MinX), s.spatial.cell(rect.MaxX)
+	minY, maxY := s.spatial.cell(rect.MinY), s.spatial.cell(rect.MaxY)
+	cells := (float64(maxX-minX) + 1) * (float64(maxY-minY) + 1)
+	if cells > maxSpatialScanCells && cells > float64(s.root.Len()) {
+		s.root.InitRawIterator(&iter)
+		if iter.SeekPrefix(s.root.Root(), nil) {
+			for _, value, ok := iter.Next(); ok; _, value, ok = iter.Next() {
+				collect(value)
+			}
+		}
+		return objs
+	}
+
+	var prefix [9]byte
+	prefix[0] = keyTagValue
+	for cx := minX; ; cx++ {
+		for cy := minY; ; cy++ {
+			binary.BigEndian.PutUint64(prefix[1:], interleave(cx, cy))
+			s.root.InitRawIterator(&iter)
+			if iter.SeekPrefix(s.root.Root(), prefix[:]) {
+				for _, value, ok := iter.Next(); ok; _, value, ok = iter.Next() {
+					collect(value)
+				}
+			}
+			if cy == maxY {
+				break
+			}
+		}
+		if cx == maxX {
+			break
+		}
+	}
+	return objs
+}
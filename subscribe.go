@@ -0,0 +1,239 @@
+package gmemdb
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// BackPressure 订阅队列满时的处理策略
+type BackPressure int
+
+const (
+	// BackPressureBlock 阻塞提交直到队列有空位
+	BackPressureBlock BackPressure = iota
+	// BackPressureDrop 丢弃新的变更
+	BackPressureDrop
+	// BackPressureCoalesce 同一对象(PrimaryID)未投递的变更合并为一条,无法合并时阻塞
+	BackPressureCoalesce
+)
+
+// ChangeEvent 已提交的变更,Old/New为提交时对象的浅拷贝
+type ChangeEvent struct {
+	Change
+	Reason int32
+}
+
+// SubscribeOptions 订阅选项,Handler不为空时在独立的goroutine中按顺序回调,
+// 否则通过Subscription.C投递;BackPressureBlock时队列满会阻塞提交所在的逻辑线程,
+// Handler或C的接收方不能同步等待逻辑线程(如投递到逻辑线程执行并等待结果),否则会死锁
+type SubscribeOptions struct {
+	Capacity int
+	Policy   BackPressure
+	Handler  func(event ChangeEvent)
+}
+
+// DefaultSubscribeCapacity 默认订阅队列长度
+const DefaultSubscribeCapacity = 1024
+
+// Subscription 表变更订阅,作为提交触发器挂在表上,变更提交后进入有界有序队列异步投递
+type Subscription struct {
+	BaseCommitTrigger
+	factory *ObjectFactory
+	opts    SubscribeOptions
+
+	lock       sync.Mutex
+	cond       *sync.Cond
+	queue      []ChangeEvent
+	delivering bool
+	closed     bool
+	dropped    int64
+
+	c    chan ChangeEvent
+	done chan struct{}
+
+	// C Handler为空时从这里接收变更,订阅关闭后C被关闭
+	C <-chan ChangeEvent
+}
+
+// Subscribe 订阅表的已提交变更
+func (s *ObjectFactory) Subscribe(opts SubscribeOptions) *Subscription {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultSubscribeCapacity
+	}
+	sub := &Subscription{
+		factory: s,
+		opts:    opts,
+		queue:   make([]ChangeEvent, 0, opts.Capacity),
+		done:    make(chan struct{}),
+	}
+	sub.cond = sync.NewCond(&sub.lock)
+	if opts.Handler == nil {
+		sub.c = make(chan ChangeEvent)
+		sub.C = sub.c
+	}
+	s.AddCommitTrigger(sub)
+	go sub.run()
+	return sub
+}
+
+// Dropped 因队列满被丢弃的变更数量
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Pending 队列中等待投递的变更数量
+func (s *Subscription) Pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.queue)
+}
+
+// Flush 等待队列中的变更全部投递完成
+func (s *Subscription) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for !s.closed && (len(s.queue) > 0 || s.delivering) {
+		s.cond.Wait()
+	}
+}
+
+// Close 取消订阅,未投递的变更被丢弃;可以在任意goroutine调用,
+// 提交触发器在逻辑线程下次提交时从表上移除
+func (s *Subscription) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	close(s.done)
+	s.cond.Broadcast()
+	s.lock.Unlock()
+}
+
+// CommitAdd CommitAdd
+func (s *Subscription) CommitAdd(fid uint32, obj IObject, reason int32) {
+	s.push(OpCreate, nil, obj, reason)
+}
+
+// CommitUpdate CommitUpdate
+func (s *Subscription) CommitUpdate(fid uint32, obj IObject, newObj IObject, reason int32) {
+	s.push(OpUpdate, obj, newObj, reason)
+}
+
+// CommitRemove CommitRemove
+func (s *Subscription) CommitRemove(fid uint32, obj IObject, reason int32) {
+	s.push(OpDelete, obj, nil, reason)
+}
+
+func (s *Subscription) push(op ChangeOp, oldObj IObject, newObj IObject, reason int32) {
+	event := ChangeEvent{
+		Change: Change{
+			Op:        op,
+			FactoryID: s.factory.FactoryID,
+			Table:     s.factory.Name,
+			Old:       copyObject(oldObj),
+			New:       copyObject(newObj),
+		},
+		Reason: reason,
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		// 在逻辑线程上移除
+		s.factory.RemoveCommitTrigger(s)
+		return
+	}
+	if s.opts.Policy == BackPressureCoalesce && s.coalesce(event) {
+		return
+	}
+	for !s.closed && len(s.queue) >= s.opts.Capacity {
+		if s.opts.Policy == BackPressureDrop {
+			atomic.AddInt64(&s.dropped, 1)
+			return
+		}
+		s.cond.Wait()
+	}
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, event)
+	s.cond.Broadcast()
+}
+
+// coalesce 合并到同一对象未投递的变更,合并后变更抵消时从队列中移除
+func (s *Subscription) coalesce(event ChangeEvent) bool {
+	id := event.objectID()
+	for i := len(s.queue) - 1; i >= 0; i-- {
+		pre := &s.queue[i]
+		if pre.objectID() != id {
+			continue
+		}
+		if !mergeChange(&pre.Change, event.Change) {
+			return false
+		}
+		pre.Reason = event.Reason
+		if pre.Op == opNone {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.cond.Broadcast()
+		}
+		return true
+	}
+	return false
+}
+
+func (s *ChangeEvent) objectID() uint32 {
+	if s.New != nil {
+		return s.New.GetID()
+	}
+	return s.Old.GetID()
+}
+
+func (s *Subscription) run() {
+	for {
+		s.lock.Lock()
+		for !s.closed && len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.lock.Unlock()
+			if s.c != nil {
+				close(s.c)
+			}
+			return
+		}
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		s.delivering = true
+		s.cond.Broadcast()
+		s.lock.Unlock()
+
+		if s.opts.Handler != nil {
+			s.opts.Handler(event)
+		} else {
+			select {
+			case s.c <- event:
+			case <-s.done:
+			}
+		}
+
+		s.lock.Lock()
+		s.delivering = false
+		s.cond.Broadcast()
+		s.lock.Unlock()
+	}
+}
+
+// copyObject 浅拷贝对象
+func copyObject(obj IObject) IObject {
+	if obj == nil {
+		return nil
+	}
+	val := reflect.ValueOf(obj).Elem()
+	newVal := reflect.New(val.Type())
+	newVal.Elem().Set(val)
+	return newVal.Interface().(IObject)
+}
@@ -0,0 +1,177 @@
+package gmemdb_test
+
+import (
+	"errors"
+
+	"github.com/jxlczjp77/gmemdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("变更订阅测试", func() {
+	var mdb *testObjMDB
+	BeforeEach(func() {
+		mdb = newTestObjMDB(true)
+		mdb.Add(&dbTestObj{Name: "张三1", ID1: 1, ID2: 10011, Address: "张三地址"}, nil, 0)
+	})
+
+	update := func(name string, address string, transaction *gmemdb.Transaction) {
+		obj := mdb.findByName(name).Step().(*dbTestObj)
+		tmp := obj.Clone()
+		tmp.Address = address
+		mdb.Update(obj, tmp, transaction, 0)
+	}
+
+	It("通道投递", func() {
+		sub := mdb.Subscribe(gmemdb.SubscribeOptions{})
+		defer sub.Close()
+
+		transaction := gmemdb.NewTransaction()
+		mdb.Add(&dbTestObj{Name: "张三2", ID1: 1, ID2: 10012}, transaction, 1)
+		update("张三1", "地址1", transaction)
+		transaction.Commit(2)
+		mdb.Remove(mdb.findByName("张三1").Step(), nil, 3)
+
+		event := <-sub.C
+		Expect(event.Op).Should(Equal(gmemdb.OpCreate))
+		Expect(event.New.(*dbTestObj).Name).Should(Equal("张三2"))
+		Expect(event.Reason).Should(Equal(int32(2)))
+		event = <-sub.C
+		Expect(event.Op).Should(Equal(gmemdb.OpUpdate))
+		Expect(event.Old.(*dbTestObj).Address).Should(Equal("张三地址"))
+		Expect(event.New.(*dbTestObj).Address).Should(Equal("地址1"))
+		event = <-sub.C
+		Expect(event.Op).Should(Equal(gmemdb.OpDelete))
+		Expect(event.Table).Should(Equal("testObjMDB"))
+		Expect(event.Reason).Should(Equal(int32(3)))
+
+		sub.Close()
+		_, ok := <-sub.C
+		Expect(ok).Should(BeFalse())
+	})
+
+	It("队列满时丢弃及合并", func() {
+		gate := make(chan struct{})
+		var received []gmemdb.ChangeEvent
+		handler := func(event gmemdb.ChangeEvent) {
+			<-gate
+			received = append(received, event)
+		}
+
+		drop := mdb.Subscribe(gmemdb.SubscribeOptions{Capacity: 2, Policy: gmemdb.BackPressureDrop, Handler: handler})
+		// 第一个变更被取出后阻塞在Handler中
+		update("张三1", "地址1", nil)
+		Eventually(drop.Pending).Should(Equal(0))
+		update("张三1", "地址2", nil)
+		update("张三1", "地址3", nil)
+		update("张三1", "地址4", nil)
+		Expect(drop.Pending()).Should(Equal(2))
+		Expect(drop.Dropped()).Should(Equal(int64(1)))
+		close(gate)
+		drop.Flush()
+		drop.Close()
+		Expect(received).Should(HaveLen(3))
+		Expect(received[2].New.(*dbTestObj).Address).Should(Equal("地址3"))
+
+		gate = make(chan struct{})
+		received = nil
+		coalesce := mdb.Subscribe(gmemdb.SubscribeOptions{Capacity: 2, Policy: gmemdb.BackPressureCoalesce, Handler: handler})
+		defer coalesce.Close()
+		update("张三1", "地址5", nil)
+		Eventually(coalesce.Pending).Should(Equal(0))
+		update("张三1", "地址6", nil)
+		update("张三1", "地址7", nil)
+		mdb.Add(&dbTestObj{Name: "张三2", ID1: 1, ID2: 10012}, nil, 0)
+		update("张三2", "地址8", nil)
+		Expect(coalesce.Pending()).Should(Equal(2))
+		close(gate)
+		coalesce.Flush()
+		Expect(received).Should(HaveLen(3))
+		Expect(received[1].Old.(*dbTestObj).Address).Should(Equal("地址5"))
+		Expect(received[1].New.(*dbTestObj).Address).Should(Equal("地址7"))
+		Expect(received[2].Op).Should(Equal(gmemdb.OpCreate))
+		Expect(received[2].New.(*dbTestObj).Address).Should(Equal("地址8"))
+
+		// 新增后删除相互抵消
+		gate = make(chan struct{})
+		received = nil
+		update("张三1", "地址9", nil)
+		Eventually(coalesce.Pending).Should(Equal(0))
+		mdb.Add(&dbTestObj{Name: "张三3", ID1: 1, ID2: 10013}, nil, 0)
+		mdb.Remove(mdb.findByName("张三3").Step(), nil, 0)
+		Expect(coalesce.Pending()).Should(Equal(0))
+		close(gate)
+		coalesce.Flush()
+		Expect(received).Should(HaveLen(1))
+	})
+
+	It("在Handler中取消订阅", func() {
+		var sub *gmemdb.Subscription
+		handled := make(chan struct{}, 4)
+		sub = mdb.Subscribe(gmemdb.SubscribeOptions{Handler: func(event gmemdb.ChangeEvent) {
+			sub.Close()
+			handled <- struct{}{}
+		}})
+		var committed int
+		mdb.AddCommitTrigger(gmemdb.MakeCommitTrigger(nil, func(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, reason int32) {
+			committed++
+		}, nil))
+
+		update("张三1", "地址1", nil)
+		<-handled
+		// 关闭后的下次提交把订阅从表上移除,不影响其他触发器
+		update("张三1", "地址2", nil)
+		update("张三1", "地址3", nil)
+		Expect(committed).Should(Equal(3))
+		Consistently(handled).ShouldNot(Receive())
+	})
+})
+
+var _ = Describe("变更日志测试", func() {
+	It("按提交序列号读取变更", func() {
+		mdb := newTestObjMDB(true)
+		log := gmemdb.NewChangeLog(2, &mdb.ObjectFactory)
+		defer log.Close()
+
+		mdb.Add(&dbTestObj{Name: "张三1", ID1: 1, ID2: 10011}, nil, 1)
+		seq1 := gmemdb.LastCommitSeq()
+
+		transaction := gmemdb.NewTransaction()
+		mdb.Add(&dbTestObj{Name: "张三2", ID1: 1, ID2: 10012}, transaction, 0)
+		mdb.Add(&dbTestObj{Name: "张三3", ID1: 1, ID2: 10013}, transaction, 0)
+		transaction.Commit(2)
+		seq2 := transaction.CommitSeq()
+		Expect(seq2).Should(BeNumerically(">", seq1))
+		transaction.Commit(0)
+		Expect(transaction.CommitSeq()).Should(Equal(uint64(0)))
+
+		batches, err := log.ReadSince(0, 0)
+		Expect(err).Should(BeNil())
+		Expect(batches).Should(HaveLen(2))
+		Expect(batches[0].Seq).Should(Equal(seq1))
+		Expect(batches[0].Reason).Should(Equal(int32(1)))
+		Expect(batches[0].Changes).Should(HaveLen(1))
+		Expect(batches[1].Seq).Should(Equal(seq2))
+		Expect(batches[1].Changes).Should(HaveLen(2))
+		Expect(batches[1].Changes[1].New.(*dbTestObj).Name).Should(Equal("张三3"))
+
+		batches, err = log.ReadSince(seq1, 0)
+		Expect(err).Should(BeNil())
+		Expect(batches).Should(HaveLen(1))
+		Expect(batches[0].Seq).Should(Equal(seq2))
+
+		Expect(mdb.Remove(mdb.findByName("张三1").Step(), nil, 3)).Should(BeTrue())
+		seq3 := gmemdb.LastCommitSeq()
+		Expect(log.FirstSeq()).Should(Equal(seq2))
+		Expect(log.LastSeq()).Should(Equal(seq3))
+		_, err = log.ReadSince(0, 0)
+		Expect(errors.Is(err, gmemdb.ErrChangeLogTruncated)).Should(BeTrue())
+		batches, err = log.ReadSince(seq1, 1)
+		Expect(err).Should(BeNil())
+		Expect(batches).Should(HaveLen(1))
+		Expect(batches[0].Seq).Should(Equal(seq2))
+		batches, err = log.ReadSince(seq3, 0)
+		Expect(err).Should(BeNil())
+		Expect(batches).Should(BeEmpty())
+	})
+})
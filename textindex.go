@@ -0,0 +1,230 @@
+package gmemdb
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jxlczjp77/gmemdb/iradix"
+)
+
+// TokenizeFunc 全文索引分词函数
+type TokenizeFunc func(text string) []string
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Tokenize 默认分词: 按空白和标点切分,字母数字组成的词转小写后作为一个token,
+// 连续的中日韩文字按二元组切分,单个中日韩文字单独作为一个token
+func Tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 {
+			tokens = append(tokens, string(cjk))
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			tokens = append(tokens, string(cjk[i:i+2]))
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+// AddTextIndex 给字符串字段添加全文索引,索引名为字段名,tokenize为nil时使用Tokenize
+func (s *ObjectFactory) AddTextIndex(field string, tokenize TokenizeFunc) int {
+	f, ok := s.Type.FieldByName(field)
+	if !ok || f.Type.Kind() != reflect.String {
+		formatndPanic("表[%s]添加全文索引失败: 列[%s]不存在或不是字符串", s.Name, field)
+	}
+	if _, ok := s.indexMap[field]; ok {
+		formatndPanic("表[%s]添加全文索引失败: 索引[%s]已存在", s.Name, field)
+	}
+	if tokenize == nil {
+		tokenize = Tokenize
+	}
+	idxNum := s.AddIndexWithOptions(field, nil, IndexOptions{
+		MultiKey: func(key *MdbKey, obj IObject) error {
+			text := reflect.ValueOf(obj).Elem().FieldByIndex(f.Index).String()
+			for _, token := range tokenize(text) {
+				key.AppendString(token)
+				if err := key.Emit(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	s.indexs[idxNum].tokenize = tokenize
+	return idxNum
+}
+
+// IsTextIndex 是否全文索引
+func (s *MemIndex) IsTextIndex() bool {
+	return s.tokenize != nil
+}
+
+// MatchAll 查找包含text所有token的对象,按PrimaryID排序
+func (s *MemIndex) MatchAll(text string) Iterator {
+	return s.matchTokens(text, true)
+}
+
+// MatchAny 查找包含text任一token的对象,按PrimaryID排序
+func (s *MemIndex) MatchAny(text string) Iterator {
+	return s.matchTokens(text, false)
+}
+
+// MatchPrefix 查找包含以prefix开头的token的对象,按PrimaryID排序;prefix使用索引的分词函数规范化,
+// 分出多个token时前面的token需要完整匹配,最后一个token按前缀匹配
+func (s *MemIndex) MatchPrefix(prefix string) Iterator {
+	s.checkTextIndex()
+	tokens := s.tokenize(prefix)
+	objs := make(map[uint32]IObject)
+	if len(tokens) == 0 {
+		return s.sortedIterator(objs)
+	}
+	s.walkToken(tokens[len(tokens)-1], false, objs)
+	for _, token := range tokens[:len(tokens)-1] {
+		exact := make(map[uint32]IObject)
+		s.walkToken(token, true, exact)
+		for id := range objs {
+			if _, ok := exact[id]; !ok {
+				delete(objs, id)
+			}
+		}
+	}
+	return s.sortedIterator(objs)
+}
+
+func (s *MemIndex) checkTextIndex() {
+	if s.tokenize == nil {
+		formatndPanic("索引[%s]不是全文索引", s.name)
+	}
+}
+
+func (s *MemIndex) matchTokens(text string, all bool) Iterator {
+	s.checkTextIndex()
+	tokens := s.tokenize(text)
+	var result map[uint32]IObject
+	for i, token := range tokens {
+		objs := make(map[uint32]IObject)
+		s.walkToken(token, true, objs)
+		switch {
+		case i == 0:
+			result = objs
+		case all:
+			for id := range result {
+				if _, ok := objs[id]; !ok {
+					delete(result, id)
+				}
+			}
+		default:
+			for id, obj := range objs {
+				result[id] = obj
+			}
+		}
+		if all && len(result) == 0 {
+			break
+		}
+	}
+	return s.sortedIterator(result)
+}
+
+// walkToken 收集token(exact为false时为token前缀)对应的对象,key为编码后的token加4字节PrimaryID
+func (s *MemIndex) walkToken(token string, exact bool, objs map[uint32]IObject) {
+	var iter iradix.RawIterator
+	s.root.InitRawIterator(&iter)
+	prefix := singleValueKey([]byte(token))
+	if !iter.SeekPrefix(s.root.Root(), prefix) {
+		return
+	}
+	for {
+		key, value, ok := iter.Next()
+		if !ok {
+			return
+		}
+		if exact && len(key) != len(prefix)+4 {
+			continue
+		}
+		obj := value.(IObject)
+		objs[obj.GetID()] = obj
+	}
+}
+
+func (s *MemIndex) sortedIterator(objs map[uint32]IObject) Iterator {
+	r := &sliceIterator{txn: s.txn, objs: make([]IObject, 0, len(objs))}
+	for _, obj := range objs {
+		r.objs = append(r.objs, obj)
+	}
+	sort.Slice(r.objs, func(i, j int) bool { return r.objs[i].GetID() < r.objs[j].GetID() })
+	return r
+}
+
+// sliceIterator 遍历查询结果集合
+type sliceIterator struct {
+	txn   *iradix.Txn
+	objs  []IObject
+	pos   int
+	value IObject
+}
+
+func (r *sliceIterator) LockDB() {
+	r.txn.LockDB()
+}
+
+func (r *sliceIterator) UnLockDB() {
+	r.txn.UnLockDB()
+}
+
+func (r *sliceIterator) RawNext() bool {
+	return r.Next()
+}
+
+func (r *sliceIterator) Next() bool {
+	if r.pos >= len(r.objs) {
+		r.value = nil
+		return false
+	}
+	r.value = r.objs[r.pos]
+	r.pos++
+	return true
+}
+
+func (r *sliceIterator) Value() IObject {
+	return r.value
+}
+
+func (r *sliceIterator) Step() IObject {
+	if r.Next() {
+		return r.Value()
+	}
+	return nil
+}
+
+func (r *sliceIterator) RawStep() IObject {
+	return r.Step()
+}
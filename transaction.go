@@ -1,7 +1,11 @@
 package gmemdb
 
 import (
+	"errors"
+	"fmt"
 	"sort"
+	"sync"
+	"time"
 )
 
 type resourceTag int
@@ -10,6 +14,7 @@ const (
 	eSavepoint resourceTag = iota
 	eTransaction
 	eDatabase
+	eParticipant
 )
 
 type mergeResult int
@@ -97,30 +102,239 @@ func (s *TransactionSavePoint) merge(preResource Resource) mergeResult {
 
 type mergeMap map[uint64][]int
 
+// undoRecord 供回滚触发器使用的动作记录,pos为动作发生后事物的资源数量
+type undoRecord struct {
+	factory *ObjectFactory
+	t       eDBResourceType
+	obj     IObject
+	newObj  IObject
+	reason  int32
+	pos     int
+}
+
+// TransactionLimits 事物限制,各项为0表示不限制
+type TransactionLimits struct {
+	MaxResources  int           // 最大资源数量(包括回滚点)
+	MaxSavePoints int           // 最大回滚点数量
+	MaxAge        time.Duration // 从第一个资源加入起的最大存活时间
+	AbortOnExceed bool          // 超出限制时由最外层操作回滚整个事物
+}
+
+// LimitKind 超出的事物限制类型
+type LimitKind int
+
+const (
+	// LimitResources 资源数量超限
+	LimitResources LimitKind = iota
+	// LimitSavePoints 回滚点数量超限
+	LimitSavePoints
+	// LimitAge 事物存活时间超限
+	LimitAge
+)
+
+func (s LimitKind) String() string {
+	switch s {
+	case LimitResources:
+		return "resources"
+	case LimitSavePoints:
+		return "savepoints"
+	case LimitAge:
+		return "age"
+	}
+	return "unknown"
+}
+
+// TransactionLimitError 超出事物限制,Aborted表示事物已被回滚
+type TransactionLimitError struct {
+	Kind    LimitKind
+	Limit   int64
+	Actual  int64
+	Aborted bool
+}
+
+func (e *TransactionLimitError) Error() string {
+	if e.Kind == LimitAge {
+		return fmt.Sprintf("事物超出限制[%s]: %v > %v", e.Kind, time.Duration(e.Actual), time.Duration(e.Limit))
+	}
+	return fmt.Sprintf("事物超出限制[%s]: %d > %d", e.Kind, e.Actual, e.Limit)
+}
+
+// TransactionStats 事物统计,记录已结束事物中的最大值
+type TransactionStats struct {
+	MaxResources  int
+	MaxSavePoints int
+	MaxAge        time.Duration
+	Exceeded      int64
+}
+
+var (
+	gstatsLock    sync.Mutex
+	gstats        TransactionStats
+	gdefaultLimit TransactionLimits
+)
+
+// GetTransactionStats 读取事物统计
+func GetTransactionStats() TransactionStats {
+	gstatsLock.Lock()
+	defer gstatsLock.Unlock()
+	return gstats
+}
+
+// ResetTransactionStats 清空事物统计
+func ResetTransactionStats() {
+	gstatsLock.Lock()
+	gstats = TransactionStats{}
+	gstatsLock.Unlock()
+}
+
+// SetDefaultTransactionLimits 设置NewTransaction使用的默认限制
+func SetDefaultTransactionLimits(limits TransactionLimits) {
+	gstatsLock.Lock()
+	gdefaultLimit = limits
+	gstatsLock.Unlock()
+}
+
 // Transaction 事物对象
 type Transaction struct {
 	resources  []Resource
 	savePoints []*TransactionSavePoint
 	merges     mergeMap
+	undoLog    []undoRecord
+
+	participants   map[Participant]uint32
+	lastError      error
+	limits         TransactionLimits
+	startTime      time.Time
+	peakResources  int
+	peakSavePoints int
+	commitSeq      uint64
+	values         map[interface{}]interface{}
 }
 
 // NewTransaction 新建事物
 func NewTransaction() *Transaction {
+	gstatsLock.Lock()
+	limits := gdefaultLimit
+	gstatsLock.Unlock()
+	return NewTransactionWithLimits(limits)
+}
+
+// NewTransactionWithLimits 新建带限制的事物
+func NewTransactionWithLimits(limits TransactionLimits) *Transaction {
 	return &Transaction{
 		resources:  make([]Resource, 0),
 		merges:     make(mergeMap),
 		savePoints: make([]*TransactionSavePoint, 0),
+		limits:     limits,
 	}
 }
 
-// AllocSavePoint 创建事物回滚点
+// SetLimits 设置事物限制
+func (s *Transaction) SetLimits(limits TransactionLimits) {
+	s.limits = limits
+}
+
+// Limits 返回事物限制
+func (s *Transaction) Limits() TransactionLimits {
+	return s.limits
+}
+
+// AllocSavePoint 创建事物回滚点,不检查事物限制,需要限制时使用TryAllocSavePoint
 func (s *Transaction) AllocSavePoint() *TransactionSavePoint {
+	return s.allocSavePoint()
+}
+
+// TryAllocSavePoint 创建事物回滚点,超出限制时返回错误
+func (s *Transaction) TryAllocSavePoint() (*TransactionSavePoint, error) {
+	if err := s.checkLimits(); err != nil {
+		return nil, s.abortOnExceed(err)
+	}
+	if max := s.limits.MaxSavePoints; max > 0 && len(s.savePoints) >= max {
+		return nil, s.abortOnExceed(s.exceed(LimitSavePoints, int64(max), int64(len(s.savePoints)+1)))
+	}
+	return s.allocSavePoint(), nil
+}
+
+func (s *Transaction) allocSavePoint() *TransactionSavePoint {
 	savePoint := &TransactionSavePoint{ts: s}
 	s.AddResource(savePoint)
 	s.savePoints = append(s.savePoints, savePoint)
+	if len(s.savePoints) > s.peakSavePoints {
+		s.peakSavePoints = len(s.savePoints)
+	}
 	return savePoint
 }
 
+// LastError 返回事物中最后一次失败操作的错误,提交或回滚后清空
+func (s *Transaction) LastError() error {
+	return s.lastError
+}
+
+func (s *Transaction) setError(err error) error {
+	if s != nil && err != nil {
+		s.lastError = err
+	}
+	return err
+}
+
+// checkLimits 在事物加入新资源前检查限制,nil事物不做检查
+func (s *Transaction) checkLimits() error {
+	if s == nil {
+		return nil
+	}
+	if max := s.limits.MaxResources; max > 0 && len(s.resources) >= max {
+		return s.exceed(LimitResources, int64(max), int64(len(s.resources)+1))
+	}
+	if max := s.limits.MaxAge; max > 0 && len(s.resources) > 0 {
+		if age := time.Since(s.startTime); age > max {
+			return s.exceed(LimitAge, int64(max), int64(age))
+		}
+	}
+	return nil
+}
+
+func (s *Transaction) exceed(kind LimitKind, limit int64, actual int64) error {
+	gstatsLock.Lock()
+	gstats.Exceeded++
+	gstatsLock.Unlock()
+	return &TransactionLimitError{Kind: kind, Limit: limit, Actual: actual}
+}
+
+// abortOnExceed 设置了AbortOnExceed时由最外层操作回滚整个事物,
+// 触发器中发起的操作只返回错误,避免在触发方操作中途回滚
+func (s *Transaction) abortOnExceed(err error) error {
+	if s == nil || !s.limits.AbortOnExceed || gtriggerFrame != nil {
+		return err
+	}
+	var limitErr *TransactionLimitError
+	if errors.As(err, &limitErr) && !limitErr.Aborted {
+		s.Rollback()
+		limitErr.Aborted = true
+	}
+	return err
+}
+
+// finish 事物结束时更新统计
+func (s *Transaction) finish() {
+	if s.peakResources == 0 {
+		return
+	}
+	age := time.Since(s.startTime)
+	gstatsLock.Lock()
+	if s.peakResources > gstats.MaxResources {
+		gstats.MaxResources = s.peakResources
+	}
+	if s.peakSavePoints > gstats.MaxSavePoints {
+		gstats.MaxSavePoints = s.peakSavePoints
+	}
+	if age > gstats.MaxAge {
+		gstats.MaxAge = age
+	}
+	gstatsLock.Unlock()
+	s.peakResources = 0
+	s.peakSavePoints = 0
+}
+
 func (s *Transaction) lastSavePoint() *TransactionSavePoint {
 	n := len(s.savePoints)
 	if n > 0 {
@@ -129,6 +343,25 @@ func (s *Transaction) lastSavePoint() *TransactionSavePoint {
 	return nil
 }
 
+// releaseSavePoint 释放最后一个回滚点,回滚点之后的修改并入上一个回滚点,
+// 之后的资源可以和回滚点之前的资源合并;savePoint不是最后一个回滚点时保留
+func (s *Transaction) releaseSavePoint(savePoint *TransactionSavePoint) {
+	n := len(s.savePoints)
+	if n == 0 || s.savePoints[n-1] != savePoint {
+		return
+	}
+	id := n - 1
+	s.savePoints = s.savePoints[:id]
+	for _, resource := range s.resources[savePoint.pos+1:] {
+		if res, ok := resource.(*DatabaseResource); ok && res.savePointID == id {
+			res.factory.releaseSavePoint(id)
+			res.savePointID = id - 1
+		}
+	}
+	// 保留pos,回滚时不会再被当作事物回滚点
+	savePoint.ts = nil
+}
+
 // LastSavePointID -1表示没有保存点,0表示第一个保存点的索引,...
 func (s *Transaction) LastSavePointID() int {
 	return len(s.savePoints) - 1
@@ -137,6 +370,9 @@ func (s *Transaction) LastSavePointID() int {
 // AddResource 添加资源到事物
 func (s *Transaction) AddResource(resource Resource) {
 	pos := len(s.resources)
+	if pos == 0 && s.peakResources == 0 {
+		s.startTime = time.Now()
+	}
 	if !resource.isControl() {
 		lastSP := s.lastSavePoint()
 		var endPos int
@@ -154,14 +390,33 @@ func (s *Transaction) AddResource(resource Resource) {
 	}
 	resource.SetPos(pos)
 	s.resources = append(s.resources, resource)
+	if len(s.resources) > s.peakResources {
+		s.peakResources = len(s.resources)
+	}
 }
 
-// Commit 提交事物
+// Commit 提交事物,参与者Prepare失败时回滚整个事物后panic,有参与者时应使用TryCommit
 func (s *Transaction) Commit(reason int32) {
+	if err := s.TryCommit(reason); err != nil {
+		formatndPanic("提交事物失败: %s", err.Error())
+	}
+}
+
+// TryCommit 提交事物,参与者Prepare失败时回滚整个事物并返回错误
+func (s *Transaction) TryCommit(reason int32) error {
 	n := len(s.resources)
 	if n == 0 {
-		return
+		s.commitSeq = 0
+		s.lastError = nil
+		s.values = nil
+		s.finish()
+		return nil
 	}
+	if err := s.prepareParticipants(); err != nil {
+		s.Rollback()
+		return err
+	}
+	s.commitSeq = nextCommitSeq()
 	var toBeCommit []Resource
 	for i := n - 1; i >= 0; i-- {
 		resource := s.resources[i]
@@ -182,6 +437,17 @@ func (s *Transaction) Commit(reason int32) {
 	s.resources = s.resources[:0]
 	s.merges = make(mergeMap)
 	s.savePoints = s.savePoints[:0]
+	s.undoLog = s.undoLog[:0]
+	s.participants = nil
+	s.lastError = nil
+	s.values = nil
+	s.finish()
+	return nil
+}
+
+// CommitSeq 事物最近一次提交分配的序列号,未提交过或提交时没有变更返回0
+func (s *Transaction) CommitSeq() uint64 {
+	return s.commitSeq
 }
 
 // Rollback 回滚事物
@@ -189,9 +455,13 @@ func (s *Transaction) Rollback() {
 	s.rollbackToSavePoint(nil)
 	s.resources = s.resources[:0]
 	s.merges = make(mergeMap)
+	s.participants = nil
+	s.lastError = nil
+	s.values = nil
 	if len(s.savePoints) != 0 {
 		panic("回滚事物失败：仍存在事物回滚点未回滚")
 	}
+	s.finish()
 }
 
 func (s *Transaction) isControl() bool {
@@ -283,10 +553,17 @@ func (s *Transaction) rollbackToSavePoint(savePoint *TransactionSavePoint) {
 		resource.free()
 	}
 
+	// 按逆序通知回滚触发器
+	for i := len(s.undoLog) - 1; i >= 0 && s.undoLog[i].pos > rollbackPos; i-- {
+		r := &s.undoLog[i]
+		r.factory.rollbackNotify(r.t, r.obj, r.newObj, r.reason)
+		s.undoLog = s.undoLog[:i]
+	}
+
 	lastSP := s.lastSavePoint()
 	for i := len(s.resources) - 1; i >= rollbackPos; i-- {
 		resource := s.resources[i]
-		if resource.tag() == eSavepoint && resource.(*TransactionSavePoint).pos == lastSP.pos {
+		if resource.tag() == eSavepoint && lastSP != nil && resource.(*TransactionSavePoint).pos == lastSP.pos {
 			n := len(s.savePoints)
 			s.savePoints = s.savePoints[:n-1]
 			lastSP = s.lastSavePoint()
@@ -299,3 +576,122 @@ func (s *Transaction) rollbackToSavePoint(savePoint *TransactionSavePoint) {
 func makeMergeID(id uint32, subID uint32) uint64 {
 	return uint64(id)<<32 | uint64(subID)
 }
+
+// ChangeOp 对象变更类型
+type ChangeOp int
+
+const (
+	opNone ChangeOp = iota - 1
+	// OpCreate 新建对象
+	OpCreate
+	// OpUpdate 更新对象
+	OpUpdate
+	// OpDelete 删除对象
+	OpDelete
+)
+
+func (s ChangeOp) String() string {
+	switch s {
+	case OpCreate:
+		return "create"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	}
+	return "none"
+}
+
+// Change 合并后的单个对象变更,新建时Old为nil,删除时New为nil
+type Change struct {
+	Op        ChangeOp
+	FactoryID uint32
+	Table     string
+	Old       IObject
+	New       IObject
+}
+
+// ChangeCount 单张表的变更数量
+type ChangeCount struct {
+	Create int
+	Update int
+	Delete int
+}
+
+// ChangeSet 事物当前持有的变更集合
+type ChangeSet struct {
+	Items  []Change
+	Counts map[string]ChangeCount
+}
+
+// Len 变更数量
+func (s *ChangeSet) Len() int {
+	return len(s.Items)
+}
+
+// Changes 返回事物中按对象合并后的变更,顺序与提交时触发器的调用顺序一致
+func (s *Transaction) Changes() *ChangeSet {
+	set := &ChangeSet{Counts: make(map[string]ChangeCount)}
+	pending := make(map[uint64]int)
+	for _, resource := range s.resources {
+		res, ok := resource.(*DatabaseResource)
+		if !ok || res.t == eNone {
+			continue
+		}
+		change := res.change()
+		id := makeMergeID(res.id(), res.subID())
+		if i, ok := pending[id]; ok && mergeChange(&set.Items[i], change) {
+			continue
+		}
+		pending[id] = len(set.Items)
+		set.Items = append(set.Items, change)
+	}
+
+	n := 0
+	for _, change := range set.Items {
+		if change.Op == opNone {
+			continue
+		}
+		set.Items[n] = change
+		n++
+		count := set.Counts[change.Table]
+		switch change.Op {
+		case OpCreate:
+			count.Create++
+		case OpUpdate:
+			count.Update++
+		case OpDelete:
+			count.Delete++
+		}
+		set.Counts[change.Table] = count
+	}
+	set.Items = set.Items[:n]
+	return set
+}
+
+// mergeChange 按DatabaseResource.merge的规则把change合并到pre,返回false表示需要单独记录
+func mergeChange(pre *Change, change Change) bool {
+	switch pre.Op {
+	case OpCreate:
+		switch change.Op {
+		case OpUpdate:
+			pre.New = change.New
+			return true
+		case OpDelete:
+			pre.Op = opNone
+			pre.New = nil
+			return true
+		}
+	case OpUpdate:
+		switch change.Op {
+		case OpUpdate:
+			pre.New = change.New
+			return true
+		case OpDelete:
+			pre.Op = OpDelete
+			pre.New = nil
+			return true
+		}
+	}
+	return false
+}
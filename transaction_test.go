@@ -0,0 +1,228 @@
+package gmemdb_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jxlczjp77/gmemdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testParticipant struct {
+	value       int
+	committed   int
+	prepared    int
+	failPrepare bool
+	snapshots   map[int]int
+}
+
+func (s *testParticipant) set(transaction *gmemdb.Transaction, v int) {
+	savePointID, err := transaction.Enlist(s)
+	Expect(err).Should(Succeed())
+	if _, ok := s.snapshots[savePointID]; !ok {
+		s.snapshots[savePointID] = s.value
+	}
+	s.value = v
+}
+
+func (s *testParticipant) Prepare() error {
+	s.prepared++
+	if s.failPrepare {
+		return errors.New("prepare failed")
+	}
+	return nil
+}
+
+func (s *testParticipant) Commit(reason int32) {
+	s.committed = s.value
+	s.snapshots = make(map[int]int)
+}
+
+func (s *testParticipant) Rollback(savePointID int) {
+	s.value = s.snapshots[savePointID]
+	for id := range s.snapshots {
+		if id >= savePointID {
+			delete(s.snapshots, id)
+		}
+	}
+}
+
+var _ = Describe("事物扩展测试", func() {
+	var mdb *testObjMDB
+	BeforeEach(func() {
+		mdb = newTestObjMDB(true)
+		mdb.Add(&dbTestObj{Name: "张三1", ID1: 1, ID2: 10011, Address: "张三地址"}, nil, 0)
+		mdb.Add(&dbTestObj{Name: "张三2", ID1: 1, ID2: 10012, Address: "张三地址"}, nil, 0)
+		mdb.Add(&dbTestObj{Name: "张三3", ID1: 1, ID2: 10013, Address: "张三地址"}, nil, 0)
+	})
+	AfterEach(func() {
+		// 修改原因全局注册,重复运行时需要注销
+		gmemdb.UnregisterReason(3801)
+	})
+
+	It("变更集测试", func() {
+		transaction := gmemdb.NewTransaction()
+		Expect(transaction.Changes().Len()).Should(BeZero())
+
+		zs1 := mdb.findByName("张三1").Step().(*dbTestObj)
+		zs1Tmp := zs1.Clone()
+		zs1Tmp.Address = "张三地址1"
+		mdb.Update(zs1, zs1Tmp, transaction, 0)
+		zs1Tmp2 := zs1Tmp.Clone()
+		zs1Tmp2.Address = "张三地址11"
+		mdb.Update(zs1Tmp, zs1Tmp2, transaction, 0)
+
+		zs4 := &dbTestObj{Name: "张三4", ID1: 1, ID2: 10014, Address: "张三地址"}
+		mdb.Add(zs4, transaction, 0)
+		transaction.AllocSavePoint()
+		zs4Tmp := zs4.Clone()
+		zs4Tmp.Address = "张三地址4"
+		mdb.Update(zs4, zs4Tmp, transaction, 0)
+
+		zs5 := &dbTestObj{Name: "张三5", ID1: 1, ID2: 10015, Address: "张三地址"}
+		mdb.Add(zs5, transaction, 0)
+		mdb.Remove(zs5, transaction, 0)
+
+		zs2 := mdb.findByName("张三2").Step()
+		mdb.Remove(zs2, transaction, 0)
+
+		changes := transaction.Changes()
+		Expect(changes.Len()).Should(Equal(3))
+		Expect(changes.Items[0].Op).Should(Equal(gmemdb.OpUpdate))
+		Expect(changes.Items[0].Old).Should(Equal(zs1))
+		Expect(changes.Items[0].New).Should(Equal(zs1Tmp2))
+		Expect(changes.Items[1].Op).Should(Equal(gmemdb.OpCreate))
+		Expect(changes.Items[1].Old).Should(BeNil())
+		Expect(changes.Items[1].New).Should(Equal(zs4Tmp))
+		Expect(changes.Items[2].Op).Should(Equal(gmemdb.OpDelete))
+		Expect(changes.Items[2].Old).Should(Equal(zs2))
+		Expect(changes.Items[2].Table).Should(Equal("testObjMDB"))
+		Expect(changes.Counts["testObjMDB"]).Should(Equal(gmemdb.ChangeCount{Create: 1, Update: 1, Delete: 1}))
+
+		transaction.Commit(0)
+		Expect(transaction.Changes().Len()).Should(BeZero())
+	})
+
+	It("事物限制测试", func() {
+		gmemdb.ResetTransactionStats()
+		transaction := gmemdb.NewTransactionWithLimits(gmemdb.TransactionLimits{MaxResources: 2, MaxSavePoints: 1})
+		Expect(mdb.TryAdd(&dbTestObj{Name: "张三4", ID1: 1, ID2: 10014}, transaction, 0)).Should(Succeed())
+		_, err := transaction.TryAllocSavePoint()
+		Expect(err).Should(Succeed())
+		_, err = transaction.TryAllocSavePoint()
+		Expect(err).Should(HaveOccurred())
+
+		err = mdb.TryAdd(&dbTestObj{Name: "张三5", ID1: 1, ID2: 10015}, transaction, 0)
+		Expect(err).Should(HaveOccurred())
+		Expect(err.(*gmemdb.TransactionLimitError).Kind).Should(Equal(gmemdb.LimitResources))
+		Expect(err.(*gmemdb.TransactionLimitError).Aborted).Should(BeFalse())
+		Expect(mdb.findByName("张三5").Step()).Should(BeNil())
+		Expect(mdb.findByName("张三4").Step()).ShouldNot(BeNil())
+		transaction.Commit(0)
+		Expect(gmemdb.GetTransactionStats().MaxResources).Should(Equal(2))
+		Expect(gmemdb.GetTransactionStats().MaxSavePoints).Should(Equal(1))
+		Expect(gmemdb.GetTransactionStats().Exceeded).Should(BeEquivalentTo(2))
+
+		// AllocSavePoint不检查限制
+		transaction.AllocSavePoint()
+		transaction.AllocSavePoint()
+		transaction.Rollback()
+
+		// 超出限制时回滚整个事物
+		transaction.SetLimits(gmemdb.TransactionLimits{MaxResources: 1, AbortOnExceed: true})
+		zs1 := mdb.findByName("张三1").Step()
+		Expect(mdb.Remove(zs1, transaction, 0)).Should(BeTrue())
+		zs2 := mdb.findByName("张三2").Step()
+		err = mdb.TryRemove(zs2, transaction, 0)
+		Expect(err.(*gmemdb.TransactionLimitError).Aborted).Should(BeTrue())
+		Expect(mdb.findByName("张三1").Step()).ShouldNot(BeNil())
+		Expect(mdb.findByName("张三2").Step()).ShouldNot(BeNil())
+		Expect(transaction.Changes().Len()).Should(BeZero())
+
+		// 存活时间限制
+		transaction.SetLimits(gmemdb.TransactionLimits{MaxAge: time.Millisecond})
+		Expect(mdb.Remove(zs1, transaction, 0)).Should(BeTrue())
+		time.Sleep(2 * time.Millisecond)
+		err = mdb.TryRemove(zs2, transaction, 0)
+		Expect(err.(*gmemdb.TransactionLimitError).Kind).Should(Equal(gmemdb.LimitAge))
+		transaction.Rollback()
+		Expect(mdb.findByName("张三1").Step()).ShouldNot(BeNil())
+	})
+
+	It("事物参与者测试", func() {
+		p := &testParticipant{snapshots: make(map[int]int)}
+		transaction := gmemdb.NewTransaction()
+		p.set(transaction, 1)
+		savePoint := transaction.AllocSavePoint()
+		p.set(transaction, 2)
+		zs1 := mdb.findByName("张三1").Step()
+		mdb.Remove(zs1, transaction, 0)
+		p.set(transaction, 3)
+		savePoint.Rollback()
+		Expect(p.value).Should(Equal(1))
+		Expect(mdb.findByName("张三1").Step()).ShouldNot(BeNil())
+
+		p.set(transaction, 4)
+		Expect(transaction.TryCommit(0)).Should(Succeed())
+		Expect(p.prepared).Should(Equal(1))
+		Expect(p.committed).Should(Equal(4))
+
+		// Prepare失败时整个事物回滚
+		p.failPrepare = true
+		p.set(transaction, 5)
+		mdb.Remove(zs1, transaction, 0)
+		Expect(transaction.TryCommit(0)).ShouldNot(Succeed())
+		Expect(p.value).Should(Equal(4))
+		Expect(p.committed).Should(Equal(4))
+		Expect(mdb.findByName("张三1").Step()).ShouldNot(BeNil())
+
+		// Commit不能忽略Prepare失败
+		p.set(transaction, 6)
+		Expect(func() { transaction.Commit(0) }).Should(Panic())
+		Expect(p.value).Should(Equal(4))
+	})
+
+	It("修改原因及事物上下文测试", func() {
+		reasonBuy := gmemdb.RegisterReasonInfo(gmemdb.ReasonInfo{Code: 3801, Name: "商店购买", Meta: map[string]interface{}{"log": true}})
+		Expect(reasonBuy.String()).Should(Equal("商店购买"))
+		Expect(gmemdb.ReasonName(3801)).Should(Equal("商店购买"))
+		Expect(gmemdb.ReasonName(3802)).Should(Equal("Reason(3802)"))
+		info, ok := gmemdb.LookupReason(3801)
+		Expect(ok).Should(BeTrue())
+		Expect(info.Meta["log"]).Should(Equal(true))
+		Expect(func() { gmemdb.RegisterReason(3801, "重复", "") }).Should(Panic())
+		Expect(gmemdb.Reasons()).Should(ContainElement(info))
+
+		type actorKey struct{}
+		var actors []interface{}
+		var reasons []string
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil,
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) {
+				actors = append(actors, transaction.Value(actorKey{}))
+				reasons = append(reasons, gmemdb.ReasonName(reason))
+			}, nil, nil,
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) bool {
+				return false
+			}))
+
+		transaction := gmemdb.NewTransaction()
+		transaction.SetValue(actorKey{}, "玩家1")
+		mdb.Add(&dbTestObj{Name: "张三4", ID1: 1, ID2: 10014}, transaction, int32(reasonBuy))
+		mdb.Add(&dbTestObj{Name: "张三5", ID1: 1, ID2: 10015}, nil, 3802)
+		Expect(actors).Should(Equal([]interface{}{"玩家1", nil}))
+		Expect(reasons).Should(Equal([]string{"商店购买", "Reason(3802)"}))
+
+		err := mdb.TryRemove(mdb.findByName("张三5").Step(), transaction, int32(reasonBuy))
+		Expect(err.Error()).Should(ContainSubstring("商店购买"))
+		transaction.ClearValues()
+		Expect(transaction.Value(actorKey{})).Should(BeNil())
+		// 提交或回滚后上下文清除
+		transaction.SetValue(actorKey{}, "玩家1")
+		transaction.Commit(0)
+		Expect(transaction.Value(actorKey{})).Should(BeNil())
+		transaction.SetValue(actorKey{}, "玩家2")
+		transaction.Rollback()
+		Expect(transaction.Value(actorKey{})).Should(BeNil())
+	})
+})
@@ -0,0 +1,128 @@
+package gmemdb
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// KeyTransform 派生索引的子键变换,生成索引key和查找时都会先变换再编码,
+// 返回值可以是MdbKey.AppendValue支持的任意类型
+type KeyTransform func(val interface{}) interface{}
+
+// IndexOptions 索引选项,Transforms按字段名指定子键变换;
+// MultiKey不为空时为多值索引,key函数每生成一个key调用一次MdbKey.Emit,
+// 多值索引不能是唯一索引,同一对象的重复key只索引一次;
+// Filter不为空时为部分索引,只索引满足条件的对象,结果只能由对象内容决定;
+// Descending中的字段在索引中倒序排列;
+// LegacyKey为true时组合key使用旧编码,字符串按长度排序,可以用ObjectFactory.MigrateIndex迁移;
+// Collations按字段名指定字符串子键的排序规则
+type IndexOptions struct {
+	Unique     bool
+	Transforms map[string]KeyTransform
+	MultiKey   MakeKeyFunc
+	Filter     func(obj IObject) bool
+	Descending []string
+	LegacyKey  bool
+	Collations map[string]Collation
+}
+
+// LowerCase 字符串转小写,用于不区分大小写的索引
+func LowerCase() KeyTransform {
+	return func(val interface{}) interface{} {
+		switch v := val.(type) {
+		case string:
+			return strings.ToLower(v)
+		case []byte:
+			return bytes.ToLower(v)
+		}
+		return val
+	}
+}
+
+const minInt = -1 << (strconv.IntSize - 1)
+
+// Truncate 整数向下取整到step的整数倍,如Truncate(86400)把秒级时间戳按天分桶,
+// 结果超出类型范围时取类型的最小值
+func Truncate(step int64) KeyTransform {
+	if step <= 0 {
+		formatndPanic("Truncate: 无效的步长%d", step)
+	}
+	// floor 向下取整,结果小于min(步长超出类型范围的负数)时取min
+	floor := func(v int64, min int64) int64 {
+		m := v % step
+		if m < 0 {
+			m += step
+		}
+		if v < min+m {
+			return min
+		}
+		return v - m
+	}
+	return func(val interface{}) interface{} {
+		switch v := val.(type) {
+		case int8:
+			return int8(floor(int64(v), math.MinInt8))
+		case int16:
+			return int16(floor(int64(v), math.MinInt16))
+		case int32:
+			return int32(floor(int64(v), math.MinInt32))
+		case int64:
+			return floor(v, math.MinInt64)
+		case int:
+			return int(floor(int64(v), minInt))
+		case uint8:
+			if step > math.MaxUint8 {
+				// 步长超出类型范围时所有值都小于步长
+				return uint8(0)
+			}
+			return v - v%uint8(step)
+		case uint16:
+			if step > math.MaxUint16 {
+				return uint16(0)
+			}
+			return v - v%uint16(step)
+		case uint32:
+			if step > math.MaxUint32 {
+				return uint32(0)
+			}
+			return v - v%uint32(step)
+		case uint64:
+			return v - v%uint64(step)
+		case uint:
+			if uint64(step) > uint64(^uint(0)) {
+				return uint(0)
+			}
+			return v - v%uint(step)
+		}
+		return val
+	}
+}
+
+// Hash 字符串按FNV-1a哈希为uint64,用于只需要等值查找的长字符串索引
+func Hash() KeyTransform {
+	return func(val interface{}) interface{} {
+		h := fnv.New64a()
+		switch v := val.(type) {
+		case string:
+			_, _ = h.Write([]byte(v))
+		case []byte:
+			_, _ = h.Write(v)
+		default:
+			return val
+		}
+		return h.Sum64()
+	}
+}
+
+// Chain 依次执行多个变换
+func Chain(transforms ...KeyTransform) KeyTransform {
+	return func(val interface{}) interface{} {
+		for _, t := range transforms {
+			val = t(val)
+		}
+		return val
+	}
+}
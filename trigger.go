@@ -1,5 +1,46 @@
 package gmemdb
 
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTriggerVeto 操作被动作触发器否决
+var ErrTriggerVeto = errors.New("操作被动作触发器否决")
+
+// TriggerVetoError 操作被动作触发器否决,Index为触发器在表中的位置,
+// Err为触发器给出的原因,返回bool的触发器否决时为ErrTriggerVeto
+type TriggerVetoError struct {
+	Table   string
+	Op      ChangeOp
+	Trigger IActionTrigger
+	Index   int
+	Reason  int32
+	Err     error
+}
+
+func (e *TriggerVetoError) Error() string {
+	return fmt.Sprintf("表[%s]%s(%s)被触发器[%s]否决: %s", e.Table, e.Op, ReasonName(e.Reason), TriggerName(e.Trigger), e.Err.Error())
+}
+
+// Unwrap 返回否决原因
+func (e *TriggerVetoError) Unwrap() error {
+	return e.Err
+}
+
+// Is 所有否决错误都匹配ErrTriggerVeto
+func (e *TriggerVetoError) Is(target error) bool {
+	return target == ErrTriggerVeto
+}
+
+// TriggerName 触发器名字,触发器实现了Name() string时使用该名字,否则使用类型名
+func TriggerName(trigger interface{}) string {
+	if p, ok := trigger.(interface{ Name() string }); ok {
+		return p.Name()
+	}
+	return fmt.Sprintf("%T", trigger)
+}
+
 // IActionTrigger 动作触发器
 type IActionTrigger interface {
 	BeforeAdd(fid uint32, obj IObject, transaction *Transaction, reason int32) bool
@@ -9,6 +50,11 @@ type IActionTrigger interface {
 	BeforeRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) bool
 }
 
+// IAfterRemoveTrigger 删除后回调,动作触发器实现该接口时对象删除后调用AfterRemove
+type IAfterRemoveTrigger interface {
+	AfterRemove(fid uint32, obj IObject, transaction *Transaction, reason int32)
+}
+
 // ICommitTrigger 提交触发器
 type ICommitTrigger interface {
 	CommitAdd(fid uint32, obj IObject, reason int32)
@@ -16,12 +62,36 @@ type ICommitTrigger interface {
 	CommitRemove(fid uint32, obj IObject, reason int32)
 }
 
+// IFieldCommitTrigger 带字段变化的提交触发器,提交触发器实现该接口时更新提交
+// 调用CommitUpdateFields代替CommitUpdate,fields为合并后新旧对象间变化的字段
+type IFieldCommitTrigger interface {
+	CommitUpdateFields(fid uint32, obj IObject, newObj IObject, fields ChangedFields, reason int32)
+}
+
+// IRollbackTrigger 回滚触发器,事物或回滚点回滚时按动作发生的逆序通知每个被撤销的动作
+type IRollbackTrigger interface {
+	RollbackAdd(fid uint32, obj IObject, reason int32)
+	RollbackUpdate(fid uint32, obj IObject, newObj IObject, reason int32)
+	RollbackRemove(fid uint32, obj IObject, reason int32)
+}
+
+// IVetoActionTrigger 可以给出否决原因的动作触发器,动作触发器实现该接口时
+// 使用CheckAdd/CheckUpdate/CheckRemove代替BeforeAdd/BeforeUpdate/BeforeRemove
+type IVetoActionTrigger interface {
+	CheckAdd(fid uint32, obj IObject, transaction *Transaction, reason int32) error
+	CheckUpdate(fid uint32, obj IObject, newObj IObject, transaction *Transaction, reason int32) error
+	CheckRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) error
+}
+
 // BaseActionTrigger BaseActionTrigger
 type BaseActionTrigger struct{}
 
 // BaseCommitTrigger BaseCommitTrigger
 type BaseCommitTrigger struct{}
 
+// BaseRollbackTrigger BaseRollbackTrigger
+type BaseRollbackTrigger struct{}
+
 // BeforeAdd BeforeAdd
 func (s *BaseActionTrigger) BeforeAdd(fid uint32, obj IObject, transaction *Transaction, reason int32) bool {
 	return true
@@ -45,6 +115,10 @@ func (s *BaseActionTrigger) BeforeRemove(fid uint32, obj IObject, transaction *T
 	return true
 }
 
+// AfterRemove AfterRemove
+func (s *BaseActionTrigger) AfterRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) {
+}
+
 // CommitAdd CommitAdd
 func (s *BaseCommitTrigger) CommitAdd(fid uint32, obj IObject, reason int32) {
 }
@@ -57,6 +131,18 @@ func (s *BaseCommitTrigger) CommitUpdate(fid uint32, obj IObject, newObj IObject
 func (s *BaseCommitTrigger) CommitRemove(fid uint32, obj IObject, reason int32) {
 }
 
+// RollbackAdd RollbackAdd
+func (s *BaseRollbackTrigger) RollbackAdd(fid uint32, obj IObject, reason int32) {
+}
+
+// RollbackUpdate RollbackUpdate
+func (s *BaseRollbackTrigger) RollbackUpdate(fid uint32, obj IObject, newObj IObject, reason int32) {
+}
+
+// RollbackRemove RollbackRemove
+func (s *BaseRollbackTrigger) RollbackRemove(fid uint32, obj IObject, reason int32) {
+}
+
 // TBeforeAdd TBeforeAdd
 type TBeforeAdd func(fid uint32, obj IObject, transaction *Transaction, reason int32) bool
 
@@ -72,6 +158,9 @@ type TAfterUpdate func(fid uint32, obj IObject, transaction *Transaction, reason
 // TBeforeRemove TBeforeRemove
 type TBeforeRemove func(fid uint32, obj IObject, transaction *Transaction, reason int32) bool
 
+// TAfterRemove TAfterRemove
+type TAfterRemove func(fid uint32, obj IObject, transaction *Transaction, reason int32)
+
 // ActionTrigger 通用版动作触发器
 type ActionTrigger struct {
 	bAdd    TBeforeAdd
@@ -79,6 +168,7 @@ type ActionTrigger struct {
 	bUpdate TBeforeUpdate
 	aUpdate TAfterUpdate
 	bRemove TBeforeRemove
+	aRemove TAfterRemove
 }
 
 // MakeActionTrigger 创建通用版动作触发器
@@ -122,6 +212,12 @@ func (s *ActionTrigger) AfterUpdate(fid uint32, obj IObject, transaction *Transa
 	}
 }
 
+// SetAfterRemove 设置AfterRemove回调
+func (s *ActionTrigger) SetAfterRemove(aRemove TAfterRemove) *ActionTrigger {
+	s.aRemove = aRemove
+	return s
+}
+
 // BeforeRemove BeforeRemove
 func (s *ActionTrigger) BeforeRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) bool {
 	if s.bRemove != nil {
@@ -130,6 +226,85 @@ func (s *ActionTrigger) BeforeRemove(fid uint32, obj IObject, transaction *Trans
 	return true
 }
 
+// AfterRemove AfterRemove
+func (s *ActionTrigger) AfterRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) {
+	if s.aRemove != nil {
+		s.aRemove(fid, obj, transaction, reason)
+	}
+}
+
+// TCheckAdd TCheckAdd
+type TCheckAdd func(fid uint32, obj IObject, transaction *Transaction, reason int32) error
+
+// TCheckUpdate TCheckUpdate
+type TCheckUpdate func(fid uint32, obj IObject, newObj IObject, transaction *Transaction, reason int32) error
+
+// TCheckRemove TCheckRemove
+type TCheckRemove func(fid uint32, obj IObject, transaction *Transaction, reason int32) error
+
+// VetoActionTrigger 通用版可给出否决原因的动作触发器
+type VetoActionTrigger struct {
+	BaseActionTrigger
+	name    string
+	cAdd    TCheckAdd
+	cUpdate TCheckUpdate
+	cRemove TCheckRemove
+}
+
+// MakeVetoActionTrigger 创建通用版可给出否决原因的动作触发器
+func MakeVetoActionTrigger(name string, cAdd TCheckAdd, cUpdate TCheckUpdate, cRemove TCheckRemove) *VetoActionTrigger {
+	return &VetoActionTrigger{
+		name:    name,
+		cAdd:    cAdd,
+		cUpdate: cUpdate,
+		cRemove: cRemove,
+	}
+}
+
+// Name 触发器名字
+func (s *VetoActionTrigger) Name() string {
+	return s.name
+}
+
+// CheckAdd CheckAdd
+func (s *VetoActionTrigger) CheckAdd(fid uint32, obj IObject, transaction *Transaction, reason int32) error {
+	if s.cAdd != nil {
+		return s.cAdd(fid, obj, transaction, reason)
+	}
+	return nil
+}
+
+// CheckUpdate CheckUpdate
+func (s *VetoActionTrigger) CheckUpdate(fid uint32, obj IObject, newObj IObject, transaction *Transaction, reason int32) error {
+	if s.cUpdate != nil {
+		return s.cUpdate(fid, obj, newObj, transaction, reason)
+	}
+	return nil
+}
+
+// CheckRemove CheckRemove
+func (s *VetoActionTrigger) CheckRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) error {
+	if s.cRemove != nil {
+		return s.cRemove(fid, obj, transaction, reason)
+	}
+	return nil
+}
+
+// BeforeAdd BeforeAdd
+func (s *VetoActionTrigger) BeforeAdd(fid uint32, obj IObject, transaction *Transaction, reason int32) bool {
+	return s.CheckAdd(fid, obj, transaction, reason) == nil
+}
+
+// BeforeUpdate BeforeUpdate
+func (s *VetoActionTrigger) BeforeUpdate(fid uint32, obj IObject, newObj IObject, transaction *Transaction, reason int32) bool {
+	return s.CheckUpdate(fid, obj, newObj, transaction, reason) == nil
+}
+
+// BeforeRemove BeforeRemove
+func (s *VetoActionTrigger) BeforeRemove(fid uint32, obj IObject, transaction *Transaction, reason int32) bool {
+	return s.CheckRemove(fid, obj, transaction, reason) == nil
+}
+
 // TCommitAdd TCommitAdd
 type TCommitAdd func(fid uint32, obj IObject, reason int32)
 
@@ -175,3 +350,100 @@ func (s *CommitTrigger) CommitRemove(fid uint32, obj IObject, reason int32) {
 		s.Remove(fid, obj, reason)
 	}
 }
+
+// TCommitUpdateFields TCommitUpdateFields
+type TCommitUpdateFields func(fid uint32, obj IObject, newObj IObject, fields ChangedFields, reason int32)
+
+// FieldCommitTrigger 通用版带字段变化的提交触发器,设置了关注字段时
+// 只有关注的字段发生变化才通知更新
+type FieldCommitTrigger struct {
+	Add    TCommitAdd
+	Update TCommitUpdateFields
+	Remove TCommitRemove
+	fields []string
+}
+
+// MakeFieldCommitTrigger 创建通用版带字段变化的提交触发器,fields为空时关注所有字段
+func MakeFieldCommitTrigger(fields []string, add TCommitAdd, update TCommitUpdateFields, remove TCommitRemove) *FieldCommitTrigger {
+	return &FieldCommitTrigger{
+		Add:    add,
+		Update: update,
+		Remove: remove,
+		fields: fields,
+	}
+}
+
+// CommitAdd CommitAdd
+func (s *FieldCommitTrigger) CommitAdd(fid uint32, obj IObject, reason int32) {
+	if s.Add != nil {
+		s.Add(fid, obj, reason)
+	}
+}
+
+// CommitUpdate CommitUpdate
+func (s *FieldCommitTrigger) CommitUpdate(fid uint32, obj IObject, newObj IObject, reason int32) {
+}
+
+// CommitUpdateFields CommitUpdateFields
+func (s *FieldCommitTrigger) CommitUpdateFields(fid uint32, obj IObject, newObj IObject, fields ChangedFields, reason int32) {
+	if s.Update == nil {
+		return
+	}
+	if len(s.fields) > 0 && !fields.HasAny(s.fields...) {
+		return
+	}
+	s.Update(fid, obj, newObj, fields, reason)
+}
+
+// CommitRemove CommitRemove
+func (s *FieldCommitTrigger) CommitRemove(fid uint32, obj IObject, reason int32) {
+	if s.Remove != nil {
+		s.Remove(fid, obj, reason)
+	}
+}
+
+// TRollbackAdd TRollbackAdd
+type TRollbackAdd func(fid uint32, obj IObject, reason int32)
+
+// TRollbackUpdate TRollbackUpdate
+type TRollbackUpdate func(fid uint32, obj IObject, newObj IObject, reason int32)
+
+// TRollbackRemove TRollbackRemove
+type TRollbackRemove func(fid uint32, obj IObject, reason int32)
+
+// RollbackTrigger 通用版回滚触发器
+type RollbackTrigger struct {
+	Add    TRollbackAdd
+	Update TRollbackUpdate
+	Remove TRollbackRemove
+}
+
+// MakeRollbackTrigger 创建通用版回滚触发器
+func MakeRollbackTrigger(add TRollbackAdd, update TRollbackUpdate, remove TRollbackRemove) *RollbackTrigger {
+	return &RollbackTrigger{
+		Add:    add,
+		Update: update,
+		Remove: remove,
+	}
+}
+
+// RollbackAdd RollbackAdd
+func (s *RollbackTrigger) RollbackAdd(fid uint32, obj IObject, reason int32) {
+	if s.Add != nil {
+		s.Add(fid, obj, reason)
+	}
+}
+
+// RollbackUpdate RollbackUpdate
+func (s *RollbackTrigger) RollbackUpdate(fid uint32, obj IObject, newObj IObject, reason int32) {
+	if s.Update != nil {
+		s.Update(fid, obj, newObj, reason)
+	}
+}
+
+// RollbackRemove RollbackRemove
+func (s *RollbackTrigger) RollbackRemove(fid uint32, obj IObject, reason int32) {
+	if s.Remove != nil {
+		s.Remove(fid, obj, reason)
+	}
+}
@@ -0,0 +1,282 @@
+package gmemdb_test
+
+import (
+	"errors"
+
+	"github.com/jxlczjp77/gmemdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// removeCounter 只实现IActionTrigger的外部触发器
+type removeCounter struct{ removes int }
+
+func (s *removeCounter) BeforeAdd(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) bool {
+	return true
+}
+func (s *removeCounter) AfterAdd(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) {
+}
+func (s *removeCounter) BeforeUpdate(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) bool {
+	return true
+}
+func (s *removeCounter) AfterUpdate(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) {
+}
+func (s *removeCounter) BeforeRemove(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) bool {
+	s.removes++
+	return true
+}
+
+var _ = Describe("触发器扩展测试", func() {
+	var mdb *testObjMDB
+	BeforeEach(func() {
+		mdb = newTestObjMDB(true)
+		mdb.Add(&dbTestObj{Name: "张三1", ID1: 1, ID2: 10011, Address: "张三地址"}, nil, 0)
+		mdb.Add(&dbTestObj{Name: "张三2", ID1: 1, ID2: 10012, Address: "张三地址"}, nil, 0)
+		mdb.Add(&dbTestObj{Name: "张三3", ID1: 1, ID2: 10013, Address: "张三地址"}, nil, 0)
+	})
+
+	It("AfterRemove及回滚触发器测试", func() {
+		var removed []string
+		trigger := gmemdb.MakeActionTrigger(nil, nil, nil, nil, nil).SetAfterRemove(
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) {
+				removed = append(removed, obj.(*dbTestObj).Name)
+			})
+		mdb.AddActionTrigger(trigger)
+		// 未实现IAfterRemoveTrigger的触发器不受影响
+		counter := mdb.AddActionTrigger(&removeCounter{}).(*removeCounter)
+
+		var undone []string
+		mdb.AddRollbackTrigger(gmemdb.MakeRollbackTrigger(
+			func(fid uint32, obj gmemdb.IObject, reason int32) {
+				undone = append(undone, "add:"+obj.(*dbTestObj).Name)
+			},
+			func(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, reason int32) {
+				undone = append(undone, "upd:"+obj.(*dbTestObj).Address+">"+newObj.(*dbTestObj).Address)
+			},
+			func(fid uint32, obj gmemdb.IObject, reason int32) {
+				undone = append(undone, "del:"+obj.(*dbTestObj).Name)
+			}))
+
+		zs1 := mdb.findByName("张三1").Step()
+		mdb.Remove(zs1, nil, 0)
+		Expect(removed).Should(Equal([]string{"张三1"}))
+		Expect(counter.removes).Should(Equal(1))
+
+		transaction := gmemdb.NewTransaction()
+		zs4 := &dbTestObj{Name: "张三4", ID1: 1, ID2: 10014, Address: "张三地址"}
+		mdb.Add(zs4, transaction, 0)
+		savePoint := transaction.AllocSavePoint()
+		zs4Tmp := zs4.Clone()
+		zs4Tmp.Address = "张三地址4"
+		mdb.Update(zs4, zs4Tmp, transaction, 0)
+		zs2 := mdb.findByName("张三2").Step()
+		mdb.Remove(zs2, transaction, 0)
+		Expect(removed).Should(Equal([]string{"张三1", "张三2"}))
+
+		savePoint.Rollback()
+		Expect(undone).Should(Equal([]string{"del:张三2", "upd:张三地址>张三地址4"}))
+
+		transaction.Rollback()
+		Expect(undone).Should(Equal([]string{"del:张三2", "upd:张三地址>张三地址4", "add:张三4"}))
+		Expect(mdb.findByName("张三4").Step()).Should(BeNil())
+
+		// 提交后不再通知
+		undone = nil
+		mdb.Remove(mdb.findByName("张三3").Step(), transaction, 0)
+		transaction.Commit(0)
+		transaction.Rollback()
+		Expect(undone).Should(BeEmpty())
+	})
+
+	It("否决原因测试", func() {
+		errFull := errors.New("背包已满")
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil, nil, nil, nil, nil))
+		mdb.AddActionTrigger(gmemdb.MakeVetoActionTrigger("背包检查",
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) error {
+				if obj.(*dbTestObj).Name == "张三4" {
+					return errFull
+				}
+				return nil
+			}, nil, nil))
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil, nil, nil, nil,
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) bool {
+				return obj.(*dbTestObj).Name != "张三1"
+			}))
+
+		err := mdb.TryAdd(&dbTestObj{Name: "张三4", ID1: 1, ID2: 10014}, nil, 0)
+		var vetoErr *gmemdb.TriggerVetoError
+		Expect(errors.As(err, &vetoErr)).Should(BeTrue())
+		Expect(vetoErr.Op).Should(Equal(gmemdb.OpCreate))
+		Expect(vetoErr.Index).Should(Equal(1))
+		Expect(gmemdb.TriggerName(vetoErr.Trigger)).Should(Equal("背包检查"))
+		Expect(errors.Is(err, errFull)).Should(BeTrue())
+		Expect(errors.Is(err, gmemdb.ErrTriggerVeto)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("背包已满"))
+		Expect(mdb.findByName("张三4").Step()).Should(BeNil())
+
+		// 返回bool的触发器依然可以否决
+		Expect(mdb.Remove(mdb.findByName("张三1").Step(), nil, 0)).Should(BeFalse())
+		err = mdb.TryRemove(mdb.findByName("张三1").Step(), nil, 0)
+		Expect(errors.As(err, &vetoErr)).Should(BeTrue())
+		Expect(vetoErr.Op).Should(Equal(gmemdb.OpDelete))
+		Expect(vetoErr.Index).Should(Equal(2))
+		Expect(vetoErr.Err).Should(Equal(gmemdb.ErrTriggerVeto))
+		Expect(mdb.Remove(mdb.findByName("张三2").Step(), nil, 0)).Should(BeTrue())
+	})
+
+	It("字段变化提交触发器测试", func() {
+		var all, money []gmemdb.ChangedFields
+		var plain int
+		mdb.AddCommitTrigger(gmemdb.MakeFieldCommitTrigger(nil, nil,
+			func(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, fields gmemdb.ChangedFields, reason int32) {
+				all = append(all, fields)
+			}, nil))
+		mdb.AddCommitTrigger(gmemdb.MakeFieldCommitTrigger([]string{"Money"}, nil,
+			func(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, fields gmemdb.ChangedFields, reason int32) {
+				money = append(money, fields)
+			}, nil))
+		mdb.AddCommitTrigger(gmemdb.MakeCommitTrigger(nil,
+			func(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, reason int32) {
+				plain++
+			}, nil))
+		Expect(func() { mdb.AddCommitTrigger(gmemdb.MakeFieldCommitTrigger([]string{"Unknown"}, nil, nil, nil)) }).Should(Panic())
+
+		zs1 := mdb.findByName("张三1").Step().(*dbTestObj)
+		tmp := zs1.Clone()
+		tmp.Address = "新地址"
+		mdb.Update(zs1, tmp, nil, 0)
+		Expect(all).Should(Equal([]gmemdb.ChangedFields{{"Address"}}))
+		Expect(money).Should(BeEmpty())
+		Expect(plain).Should(Equal(1))
+
+		// 事物中多次更新合并后再比较
+		transaction := gmemdb.NewTransaction()
+		zs2 := mdb.findByName("张三2").Step().(*dbTestObj)
+		tmp = zs2.Clone()
+		tmp.Money = 100
+		tmp.ID2 = 20012
+		mdb.Update(zs2, tmp, transaction, 0)
+		tmp2 := tmp.Clone()
+		tmp2.ID2 = 10012
+		tmp2.Address = "地址2"
+		mdb.Update(tmp, tmp2, transaction, 0)
+		transaction.Commit(0)
+		Expect(all).Should(Equal([]gmemdb.ChangedFields{{"Address"}, {"Address", "Money"}}))
+		Expect(money).Should(Equal([]gmemdb.ChangedFields{{"Address", "Money"}}))
+		Expect(money[0].Has("ID2")).Should(BeFalse())
+		Expect(plain).Should(Equal(2))
+
+		// 包含接口的字段中保存不可比较的值时不能panic
+		type extraObj struct {
+			gmemdb.ObjectBase
+			Attr  interface{}
+			Extra struct{ Tags interface{} }
+		}
+		a, b := &extraObj{Attr: []int{1}}, &extraObj{Attr: []int{1}}
+		a.Extra.Tags = []string{"a"}
+		b.Extra.Tags = []string{"b"}
+		Expect((&gmemdb.ObjectFactory{}).ChangedFields(a, b)).Should(Equal(gmemdb.ChangedFields{"Extra"}))
+	})
+
+	It("触发器优先级及过滤测试", func() {
+		var order []string
+		after := func(name string) gmemdb.TAfterAdd {
+			return func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) {
+				order = append(order, name)
+			}
+		}
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil, after("逻辑1"), nil, nil, nil))
+		mdb.AddActionTriggerWithOptions(gmemdb.MakeActionTrigger(nil, after("框架"), nil, nil, nil), gmemdb.TriggerOptions{Priority: 10})
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil, after("逻辑2"), nil, nil, nil))
+		mdb.AddActionTriggerWithOptions(gmemdb.MakeActionTrigger(nil, after("日志"), nil, nil, nil), gmemdb.TriggerOptions{Priority: -1})
+		filtered := mdb.AddActionTriggerWithOptions(gmemdb.MakeActionTrigger(nil, after("过滤"), nil, nil, nil), gmemdb.TriggerOptions{
+			Priority: 10,
+			Reasons:  []int32{1, 2},
+			Filter:   func(obj gmemdb.IObject) bool { return obj.(*dbTestObj).ID1 == 2 },
+		})
+
+		mdb.Add(&dbTestObj{Name: "张三4", ID1: 1, ID2: 10014}, nil, 1)
+		Expect(order).Should(Equal([]string{"框架", "逻辑1", "逻辑2", "日志"}))
+		order = nil
+		mdb.Add(&dbTestObj{Name: "张三5", ID1: 2, ID2: 10015}, nil, 3)
+		Expect(order).Should(Equal([]string{"框架", "逻辑1", "逻辑2", "日志"}))
+		order = nil
+		mdb.Add(&dbTestObj{Name: "张三6", ID1: 2, ID2: 10016}, nil, 2)
+		Expect(order).Should(Equal([]string{"框架", "过滤", "逻辑1", "逻辑2", "日志"}))
+
+		// 重新注册后使用新的优先级
+		order = nil
+		mdb.AddActionTriggerWithOptions(filtered, gmemdb.TriggerOptions{Priority: -10})
+		mdb.Add(&dbTestObj{Name: "张三7", ID1: 2, ID2: 10017}, nil, 0)
+		Expect(order).Should(Equal([]string{"框架", "逻辑1", "逻辑2", "日志", "过滤"}))
+		mdb.RemoveActionTrigger(filtered)
+
+		var commits []gmemdb.ChangeOp
+		mdb.AddCommitTriggerWithOptions(gmemdb.MakeCommitTrigger(
+			func(fid uint32, obj gmemdb.IObject, reason int32) { commits = append(commits, gmemdb.OpCreate) },
+			func(fid uint32, obj gmemdb.IObject, newObj gmemdb.IObject, reason int32) {
+				commits = append(commits, gmemdb.OpUpdate)
+			},
+			func(fid uint32, obj gmemdb.IObject, reason int32) { commits = append(commits, gmemdb.OpDelete) },
+		), gmemdb.TriggerOptions{Ops: []gmemdb.ChangeOp{gmemdb.OpCreate, gmemdb.OpDelete}})
+		mdb.Add(&dbTestObj{Name: "张三8", ID1: 1, ID2: 10018}, nil, 0)
+		zs8 := mdb.findByName("张三8").Step().(*dbTestObj)
+		tmp := zs8.Clone()
+		tmp.Address = "地址8"
+		mdb.Update(zs8, tmp, nil, 0)
+		mdb.Remove(tmp, nil, 0)
+		Expect(commits).Should(Equal([]gmemdb.ChangeOp{gmemdb.OpCreate, gmemdb.OpDelete}))
+	})
+
+	It("触发器中级联写操作测试", func() {
+		members := newMemberMDB()
+		members.AddCheck("Name", gmemdb.CheckNotEmpty())
+		var nestedErr error
+		mdb.AddActionTrigger(gmemdb.MakeActionTrigger(nil,
+			func(fid uint32, obj gmemdb.IObject, transaction *gmemdb.Transaction, reason int32) {
+				o := obj.(*dbTestObj)
+				nestedErr = members.TryAdd(&dbMemberObj{GuildName: o.Address, Name: o.Address}, nil, reason)
+				if o.ID1 == 3 {
+					mdb.Remove(o, nil, reason)
+				}
+			}, nil, nil, nil))
+
+		// 嵌套写操作加入触发方的事物
+		transaction := gmemdb.NewTransaction()
+		Expect(mdb.TryAdd(&dbTestObj{Name: "张三4", ID1: 1, ID2: 10014, Address: "成员4"}, transaction, 0)).Should(Succeed())
+		Expect(members.findByName("成员4").Step()).ShouldNot(BeNil())
+		transaction.Rollback()
+		Expect(members.findByName("成员4").Step()).Should(BeNil())
+		Expect(mdb.findByName("张三4").Step()).Should(BeNil())
+
+		// 嵌套写操作的错误由触发方返回
+		err := mdb.TryAdd(&dbTestObj{Name: "张三5", ID1: 1, ID2: 10015}, transaction, 0)
+		var validationErr *gmemdb.ValidationError
+		Expect(errors.As(err, &validationErr)).Should(BeTrue())
+		Expect(nestedErr).Should(Equal(err))
+		Expect(transaction.LastError()).Should(Equal(err))
+		transaction.Rollback()
+
+		// 非事物操作的触发器中的嵌套写操作和触发方一起提交
+		Expect(mdb.TryAdd(&dbTestObj{Name: "张三8", ID1: 1, ID2: 10018, Address: "成员8"}, nil, 0)).Should(Succeed())
+		Expect(members.findByName("成员8").Step()).ShouldNot(BeNil())
+		Expect(mdb.findByName("张三8").Step()).ShouldNot(BeNil())
+
+		// 触发器中修改本表,非事物操作和触发器中的嵌套写操作一起回滚
+		err = mdb.TryAdd(&dbTestObj{Name: "张三6", ID1: 3, ID2: 10016, Address: "成员6"}, nil, 0)
+		var reentryErr *gmemdb.TriggerReentryError
+		Expect(errors.As(err, &reentryErr)).Should(BeTrue())
+		Expect(reentryErr.Table).Should(Equal("testObjMDB"))
+		Expect(reentryErr.Op).Should(Equal(gmemdb.OpDelete))
+		Expect(mdb.findByName("张三6").Step()).Should(BeNil())
+		Expect(members.findByName("成员6").Step()).Should(BeNil())
+
+		// 嵌套深度限制
+		old := gmemdb.MaxTriggerDepth
+		gmemdb.MaxTriggerDepth = 1
+		defer func() { gmemdb.MaxTriggerDepth = old }()
+		err = mdb.TryAdd(&dbTestObj{Name: "张三7", ID1: 1, ID2: 10017, Address: "成员7"}, nil, 0)
+		Expect(err).Should(Equal(gmemdb.ErrTriggerDepth))
+		Expect(members.findByName("成员7").Step()).Should(BeNil())
+	})
+})
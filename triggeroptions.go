@@ -0,0 +1,93 @@
+package gmemdb
+
+// TriggerOptions 触发器注册选项,Priority大的先执行,相同优先级按注册顺序执行;
+// Ops、Reasons为空表示不过滤,Filter对新增和更新判断新对象,对删除判断被删除的对象
+type TriggerOptions struct {
+	Priority int
+	Ops      []ChangeOp
+	Reasons  []int32
+	Filter   func(obj IObject) bool
+}
+
+func (s *TriggerOptions) match(op ChangeOp, reason int32, obj IObject) bool {
+	if s == nil {
+		return true
+	}
+	if len(s.Ops) > 0 {
+		found := false
+		for _, o := range s.Ops {
+			if o == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.Reasons) > 0 {
+		found := false
+		for _, r := range s.Reasons {
+			if r == reason {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return s.Filter == nil || s.Filter(obj)
+}
+
+func (s *TriggerOptions) priority() int {
+	if s == nil {
+		return 0
+	}
+	return s.Priority
+}
+
+// triggerInsertPos 返回优先级为priority的触发器插入位置
+func triggerInsertPos(options []*TriggerOptions, priority int) int {
+	for i, opts := range options {
+		if opts.priority() < priority {
+			return i
+		}
+	}
+	return len(options)
+}
+
+// AddActionTriggerWithOptions 按优先级及过滤条件添加Action触发器
+func (s *ObjectFactory) AddActionTriggerWithOptions(p IActionTrigger, opts TriggerOptions) IActionTrigger {
+	return s.addActionTrigger(p, &opts)
+}
+
+// AddCommitTriggerWithOptions 按优先级及过滤条件添加Commit触发器
+func (s *ObjectFactory) AddCommitTriggerWithOptions(p ICommitTrigger, opts TriggerOptions) ICommitTrigger {
+	return s.addCommitTrigger(p, &opts)
+}
+
+func (s *ObjectFactory) addActionTrigger(p IActionTrigger, opts *TriggerOptions) IActionTrigger {
+	s.RemoveActionTrigger(p)
+	pos := triggerInsertPos(s.actionOptions, opts.priority())
+	s.actionTriggers = append(s.actionTriggers, nil)
+	copy(s.actionTriggers[pos+1:], s.actionTriggers[pos:])
+	s.actionTriggers[pos] = p
+	s.actionOptions = append(s.actionOptions, nil)
+	copy(s.actionOptions[pos+1:], s.actionOptions[pos:])
+	s.actionOptions[pos] = opts
+	return p
+}
+
+func (s *ObjectFactory) addCommitTrigger(p ICommitTrigger, opts *TriggerOptions) ICommitTrigger {
+	s.checkWatchFields(p)
+	s.RemoveCommitTrigger(p)
+	pos := triggerInsertPos(s.commitOptions, opts.priority())
+	s.commitTriggers = append(s.commitTriggers, nil)
+	copy(s.commitTriggers[pos+1:], s.commitTriggers[pos:])
+	s.commitTriggers[pos] = p
+	s.commitOptions = append(s.commitOptions, nil)
+	copy(s.commitOptions[pos+1:], s.commitOptions[pos:])
+	s.commitOptions[pos] = opts
+	return p
+}
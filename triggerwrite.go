@@ -0,0 +1,161 @@
+package gmemdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxTriggerDepth 动作触发器中嵌套写操作的最大深度
+var MaxTriggerDepth = 8
+
+// ErrTriggerDepth 触发器嵌套写操作超过MaxTriggerDepth
+var ErrTriggerDepth = errors.New("触发器嵌套写操作超过最大深度")
+
+// TriggerReentryError 动作触发器中修改正在触发的表
+type TriggerReentryError struct {
+	Table string
+	Op    ChangeOp
+}
+
+func (e *TriggerReentryError) Error() string {
+	return fmt.Sprintf("表[%s]的动作触发器中不能%s本表对象", e.Table, e.Op)
+}
+
+// triggerFrame 正在执行的动作触发器,触发器中没有传入事物的写操作加入触发方的事物,
+// 触发方没有事物时加入触发方的非事物写操作;写操作失败时错误记录到这里并由触发方返回
+type triggerFrame struct {
+	factory     *ObjectFactory
+	transaction *Transaction
+	parent      *triggerFrame
+	depth       int
+	err         error
+}
+
+// pendingWrite 非事物写操作中已修改表但还未提交的操作
+type pendingWrite struct {
+	factory *ObjectFactory
+	t       eDBResourceType
+	obj     IObject
+	newObj  IObject
+	reason  int32
+	notify  bool
+}
+
+// nilWrite 会执行动作触发器的非事物写操作,触发器中发起的非事物写操作只修改表,
+// 最外层操作结束时一起提交,失败时通过表的txn回滚
+type nilWrite struct {
+	pending []pendingWrite
+}
+
+// 内存表只在逻辑线程中使用,正在执行的触发器和非事物写操作记录在全局变量中
+var (
+	gtriggerFrame *triggerFrame
+	gnilWrite     *nilWrite
+)
+
+// enterTriggers 开始执行表的动作触发器,表上记录正在执行的触发器用于检查修改本表
+func (s *ObjectFactory) enterTriggers(transaction *Transaction) *triggerFrame {
+	frame := &triggerFrame{factory: s, transaction: transaction, parent: gtriggerFrame, depth: 1}
+	if frame.parent != nil {
+		frame.depth = frame.parent.depth + 1
+	}
+	s.triggerFrame = frame
+	gtriggerFrame = frame
+	return frame
+}
+
+func leaveTriggers(frame *triggerFrame) {
+	if gtriggerFrame != frame {
+		formatndPanic("表[%s]触发器调用栈不匹配", frame.factory.Name)
+	}
+	frame.factory.triggerFrame = nil
+	gtriggerFrame = frame.parent
+}
+
+// joinTriggerWrite 检查触发器中发起的写操作,没有传入事物时返回触发方的事物,
+// 修改正在触发的表时错误同时记录到该表的触发器
+func (s *ObjectFactory) joinTriggerWrite(op ChangeOp, transaction *Transaction) (*Transaction, error) {
+	if frame := s.triggerFrame; frame != nil {
+		err := &TriggerReentryError{Table: s.Name, Op: op}
+		if frame.err == nil {
+			frame.err = err
+		}
+		return transaction, err
+	}
+	frame := gtriggerFrame
+	if frame == nil {
+		return transaction, nil
+	}
+	if transaction == nil {
+		transaction = frame.transaction
+	}
+	if frame.depth >= MaxTriggerDepth {
+		return transaction, ErrTriggerDepth
+	}
+	return transaction, nil
+}
+
+// recordTriggerError 触发器中发起的写操作失败时记录错误,触发方操作返回第一个错误
+func recordTriggerError(err error) error {
+	if frame := gtriggerFrame; frame != nil && err != nil && frame.err == nil {
+		frame.err = err
+	}
+	return err
+}
+
+// withNilWrite 执行会触发动作触发器的非事物写操作,触发器中发起的非事物写操作加入本操作
+func withNilWrite(fn func() error) error {
+	w := &nilWrite{}
+	gnilWrite = w
+	done := false
+	defer func() {
+		if !done {
+			// 索引修改失败panic时回滚已修改的表
+			gnilWrite = nil
+			w.rollback()
+		}
+	}()
+	err := fn()
+	done = true
+	gnilWrite = nil
+	if err != nil {
+		w.rollback()
+		return err
+	}
+	for _, p := range w.pending {
+		p.factory.commit()
+	}
+	seq := nextCommitSeq()
+	for _, p := range w.pending {
+		p.notifyCommit(seq)
+	}
+	return nil
+}
+
+func (s *pendingWrite) notifyCommit(seq uint64) {
+	switch s.t {
+	case eCreate:
+		s.factory.commitAdd(s.obj, seq, s.reason, s.notify)
+	case eUpdate:
+		s.factory.commitUpdate(s.obj, s.newObj, seq, s.reason, s.notify)
+	case eDelete:
+		s.factory.commitRemove(s.obj, seq, s.reason, s.notify)
+	}
+}
+
+func (s *nilWrite) rollback() {
+	for i := len(s.pending) - 1; i >= 0; i-- {
+		s.pending[i].factory.rollback()
+	}
+}
+
+// commitNilWrite 提交非事物写操作,在非事物写操作的触发器中时只记录,由最外层操作提交
+func (s *ObjectFactory) commitNilWrite(t eDBResourceType, obj IObject, newObj IObject, reason int32, notify bool) {
+	p := pendingWrite{factory: s, t: t, obj: obj, newObj: newObj, reason: reason, notify: notify}
+	if gnilWrite != nil {
+		gnilWrite.pending = append(gnilWrite.pending, p)
+		return
+	}
+	s.commit()
+	p.notifyCommit(nextCommitSeq())
+}
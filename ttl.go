@@ -0,0 +1,162 @@
+package gmemdb
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ReasonExpire 过期删除使用的修改原因
+const ReasonExpire int32 = math.MinInt32
+
+func init() {
+	RegisterReason(ReasonExpire, "过期删除", "表设置了过期时间,对象到期后被删除")
+}
+
+// ExpireFunc 返回对象的过期时间,零值表示永不过期
+type ExpireFunc func(obj IObject) time.Time
+
+type ttlInfo struct {
+	idx    *MemIndex
+	expire ExpireFunc
+}
+
+// SetExpireField 使用time.Time或int64(Unix秒)字段作为过期时间
+func (s *ObjectFactory) SetExpireField(field string) {
+	f, ok := s.Type.FieldByName(field)
+	if !ok {
+		formatndPanic("表[%s]设置过期时间失败: 列[%s]不存在", s.Name, field)
+	}
+	var fn ExpireFunc
+	switch {
+	case f.Type == reflect.TypeOf(time.Time{}):
+		fn = func(obj IObject) time.Time {
+			return reflect.ValueOf(obj).Elem().FieldByIndex(f.Index).Interface().(time.Time)
+		}
+	case f.Type.Kind() == reflect.Int64:
+		fn = func(obj IObject) time.Time {
+			if v := reflect.ValueOf(obj).Elem().FieldByIndex(f.Index).Int(); v != 0 {
+				return time.Unix(v, 0)
+			}
+			return time.Time{}
+		}
+	default:
+		formatndPanic("表[%s]设置过期时间失败: 列[%s]不是time.Time或int64", s.Name, field)
+	}
+	s.setExpire(field, fn)
+}
+
+// SetExpireFunc 使用函数计算过期时间,结果只能由对象内容决定
+func (s *ObjectFactory) SetExpireFunc(fn ExpireFunc) {
+	s.setExpire("PrimaryID", fn)
+}
+
+func (s *ObjectFactory) setExpire(field string, fn ExpireFunc) {
+	if s.ttl != nil {
+		formatndPanic("表[%s]设置过期时间失败: 已经设置过", s.Name)
+	}
+	idxNum := s.AddIndexWithOptions(field+"#ttl", func(key *MdbKey, obj IObject) error {
+		return key.AppendInt64(fn(obj).UnixNano())
+	}, IndexOptions{Filter: func(obj IObject) bool { return !fn(obj).IsZero() }})
+	s.ttl = &ttlInfo{idx: s.indexs[idxNum], expire: fn}
+}
+
+// NextExpire 最早的过期时间,没有会过期的对象时返回false
+func (s *ObjectFactory) NextExpire() (time.Time, bool) {
+	if s.ttl == nil {
+		return time.Time{}, false
+	}
+	obj := s.ttl.idx.Begin().Step()
+	if obj == nil {
+		return time.Time{}, false
+	}
+	return s.ttl.expire(obj), true
+}
+
+// Expire 删除过期时间不晚于now的对象,删除原因为ReasonExpire;transaction为nil时
+// 在内部事物中删除,任一对象删除失败则整体回滚并返回0及失败原因
+// 传入transaction时由调用方提交,删除失败的对象被跳过,返回删除的数量及第一个失败原因;
+// 动作触发器收到ReasonExpire,提交触发器和变更日志收到的是调用方Commit时传入的原因
+func (s *ObjectFactory) Expire(now time.Time, transaction *Transaction) (int, error) {
+	if s.ttl == nil {
+		return 0, nil
+	}
+	var expired []IObject
+	for it := s.ttl.idx.Begin(); it.Next(); {
+		if s.ttl.expire(it.Value()).After(now) {
+			break
+		}
+		expired = append(expired, it.Value())
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	if transaction == nil {
+		transaction = NewTransaction()
+		for _, obj := range expired {
+			if err := s.TryRemove(obj, transaction, ReasonExpire); err != nil {
+				transaction.Rollback()
+				return 0, err
+			}
+		}
+		if err := transaction.TryCommit(ReasonExpire); err != nil {
+			return 0, err
+		}
+		return len(expired), nil
+	}
+
+	n := 0
+	var firstErr error
+	for _, obj := range expired {
+		if err := s.TryRemove(obj, transaction, ReasonExpire); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		n++
+	}
+	return n, firstErr
+}
+
+// ExpireSweeper 定时删除过期对象
+type ExpireSweeper struct {
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// StartExpireSweeper 每隔interval删除一次过期对象;内存表不是线程安全的,
+// 删除操作通过post投递到逻辑线程执行,post不能为空
+func (s *ObjectFactory) StartExpireSweeper(interval time.Duration, post func(fn func())) *ExpireSweeper {
+	if post == nil {
+		formatndPanic("表[%s]启动过期删除失败: post不能为空", s.Name)
+	}
+	sweeper := &ExpireSweeper{stop: make(chan struct{})}
+	sweep := func() {
+		_, _ = s.Expire(time.Now(), nil)
+	}
+	sweeper.wg.Add(1)
+	go func() {
+		defer sweeper.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				post(sweep)
+			case <-sweeper.stop:
+				return
+			}
+		}
+	}()
+	return sweeper
+}
+
+// Stop 停止定时删除,已经投递的删除操作不会被取消
+func (s *ExpireSweeper) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	s.wg.Wait()
+}